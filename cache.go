@@ -12,24 +12,34 @@ import (
 var DisableSelectorCache = false
 
 // SelectorCacheMaxEntries allows how many selector object can be caching. Default is 50.
-// Will disable caching if SelectorCacheMaxEntries <= 0.
+// Will disable caching if SelectorCacheMaxEntries <= 0. A new value takes
+// effect once the cache is created, on first use or after ClearSelectorCache.
 var SelectorCacheMaxEntries = 50
 
 var (
-	cacheOnce  sync.Once
 	cache      *lru.Cache
 	cacheMutex sync.Mutex
 )
 
+// ClearSelectorCache removes all compiled expressions from the selector
+// cache. The next query creates a new cache of SelectorCacheMaxEntries
+// entries.
+func ClearSelectorCache() {
+	cacheMutex.Lock()
+	cache = nil
+	cacheMutex.Unlock()
+}
+
 func getQuery(expr string) (*xpath.Expr, error) {
+	expr = rewriteContextCalls(rewriteIDCalls(expr))
 	if DisableSelectorCache || SelectorCacheMaxEntries <= 0 {
 		return xpath.Compile(expr)
 	}
-	cacheOnce.Do(func() {
-		cache = lru.New(SelectorCacheMaxEntries)
-	})
 	cacheMutex.Lock()
 	defer cacheMutex.Unlock()
+	if cache == nil {
+		cache = lru.New(SelectorCacheMaxEntries)
+	}
 	if v, ok := cache.Get(expr); ok {
 		return v.(*xpath.Expr), nil
 	}
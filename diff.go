@@ -0,0 +1,220 @@
+package jsonquery
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+)
+
+// DiffSummary compares the values of a and b and returns a summary of
+// the differences, one per line, such as:
+//
+//	~ cars[1].name: BMW -> Audi
+//	+ cars[3]
+//	- age
+//
+// A "~" line is a value that changed, "+" a value only present in b and
+// "-" a value only present in a. Object members are matched by key and
+// array elements by position. If color is true, lines are colored with
+// ANSI escape codes for display on a terminal. It returns an empty
+// string if a and b are equal.
+func DiffSummary(a, b *Node, color bool) string {
+	var buf bytes.Buffer
+	d := &differ{buf: &buf, color: color}
+	d.diff("", a, b)
+	return buf.String()
+}
+
+// EqualJSON reports whether the value of n is equal to the JSON
+// document s. Objects are compared regardless of the order of their
+// members and numbers by their value, so 1.0 equals 1. If the values
+// differ, the returned error holds their DiffSummary; it also reports
+// a failure to parse s.
+func (n *Node) EqualJSON(s string) (bool, error) {
+	doc, err := Parse(strings.NewReader(s))
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(n.ToValue(), doc.ToValue()) {
+		return true, nil
+	}
+	return false, fmt.Errorf("jsonquery: values differ:\n%s", DiffSummary(n, doc, false))
+}
+
+// Equal reports whether n and other hold the same tree: nodes of the
+// same types with the same Data and ValueType, and equal children.
+// Members of objects are matched by key regardless of their order, so
+// documents parsed with and without PreserveOrder are equal, while
+// elements of arrays are compared by position. Numbers are compared
+// by their text, so 1.0 does not equal 1; use EqualJSON to compare
+// values. The parents and levels of n and other are ignored, so
+// subtrees of different documents can be compared.
+func (n *Node) Equal(other *Node) bool {
+	return equalNodes(n, other, false)
+}
+
+// EqualOrdered is like Equal but also requires the members of objects
+// to be in the same order.
+func (n *Node) EqualOrdered(other *Node) bool {
+	return equalNodes(n, other, true)
+}
+
+func equalNodes(a, b *Node, ordered bool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type || a.Data != b.Data || a.ValueType != b.ValueType {
+		return false
+	}
+	if a.Type == TextNode {
+		return true
+	}
+	if a.IsArray() != b.IsArray() || a.IsObject() != b.IsObject() {
+		return false
+	}
+	if ordered || !a.IsObject() {
+		x, y := a.FirstChild, b.FirstChild
+		for ; x != nil && y != nil; x, y = x.NextSibling, y.NextSibling {
+			if !equalNodes(x, y, ordered) {
+				return false
+			}
+		}
+		return x == nil && y == nil
+	}
+	// Match members by key. Members of a key that appears more than
+	// once are matched in order.
+	members := make(map[string][]*Node)
+	count := 0
+	for child := b.FirstChild; child != nil; child = child.NextSibling {
+		members[child.Data] = append(members[child.Data], child)
+		count++
+	}
+	for child := a.FirstChild; child != nil; child = child.NextSibling {
+		m := members[child.Data]
+		if len(m) == 0 || !equalNodes(child, m[0], ordered) {
+			return false
+		}
+		members[child.Data] = m[1:]
+		count--
+	}
+	return count == 0
+}
+
+type differ struct {
+	buf   *bytes.Buffer
+	color bool
+}
+
+func (d *differ) line(c, op, path, detail string) {
+	if path == "" {
+		path = "."
+	}
+	if d.color {
+		d.buf.WriteString(c)
+	}
+	d.buf.WriteString(op + " " + path + detail)
+	if d.color {
+		d.buf.WriteString(colorReset)
+	}
+	d.buf.WriteByte('\n')
+}
+
+func (d *differ) diff(path string, a, b *Node) {
+	ta, tb := scalar(a), scalar(b)
+	switch {
+	case ta != nil || tb != nil || isNull(a) || isNull(b) || a.IsArray() != b.IsArray():
+		if ta != nil && tb != nil && ta.Data == tb.Data && ta.ValueType == tb.ValueType {
+			return
+		}
+		if ta == nil && tb == nil && isNull(a) && isNull(b) {
+			return
+		}
+		d.line(colorYellow, "~", path, ": "+summary(a)+" -> "+summary(b))
+	case a.IsArray():
+		ca, cb := a.FirstChild, b.FirstChild
+		for i := 0; ca != nil || cb != nil; i++ {
+			p := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case cb == nil:
+				d.line(colorRed, "-", p, "")
+			case ca == nil:
+				d.line(colorGreen, "+", p, "")
+			default:
+				d.diff(p, ca, cb)
+			}
+			if ca != nil {
+				ca = ca.NextSibling
+			}
+			if cb != nil {
+				cb = cb.NextSibling
+			}
+		}
+	default:
+		join := func(key string) string {
+			if path == "" {
+				return key
+			}
+			return path + "." + key
+		}
+		members := make(map[string]*Node)
+		for child := b.FirstChild; child != nil; child = child.NextSibling {
+			if _, ok := members[child.Data]; !ok {
+				members[child.Data] = child
+			}
+		}
+		seen := make(map[string]bool)
+		for child := a.FirstChild; child != nil; child = child.NextSibling {
+			if seen[child.Data] {
+				continue
+			}
+			seen[child.Data] = true
+			if other, ok := members[child.Data]; ok {
+				d.diff(join(child.Data), child, other)
+			} else {
+				d.line(colorRed, "-", join(child.Data), "")
+			}
+		}
+		for child := b.FirstChild; child != nil; child = child.NextSibling {
+			if !seen[child.Data] {
+				seen[child.Data] = true
+				d.line(colorGreen, "+", join(child.Data), "")
+			}
+		}
+	}
+}
+
+// isNull reports whether n is an element without children that is
+// not known to be an empty array or object.
+func isNull(n *Node) bool {
+	return n.FirstChild == nil && n.Container == NoContainer
+}
+
+// summary returns a short description of the value of n.
+func summary(n *Node) string {
+	if t := scalar(n); t != nil {
+		if t.ValueType == NullValue {
+			return "null"
+		}
+		return t.Data
+	}
+	switch {
+	case isNull(n):
+		return "null"
+	case n.FirstChild == nil && n.IsArray():
+		return "[]"
+	case n.FirstChild == nil:
+		return "{}"
+	case n.IsArray():
+		return "[...]"
+	}
+	return "{...}"
+}
@@ -0,0 +1,134 @@
+package jsonquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffSummary(t *testing.T) {
+	a, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := parseString(`{
+		"name":"John",
+		"motorist": "yes",
+		"cars": [
+			{ "name":"Ford", "models":[ "Fiesta", "Focus", "Mustang" ] },
+			{ "name":"Audi", "models":[ "320", "X3", "X5" ] },
+			{ "name":"Fiat", "models":[ "500" ] },
+			{ "name":"Kia", "models":[] }
+		],
+		"city": "Nara"
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"- age",
+		"~ cars[1].name: BMW -> Audi",
+		"- cars[2].models[1]",
+		"+ cars[3]",
+		"~ motorist: true -> yes",
+		"+ city",
+	}, "\n") + "\n"
+	if g := DiffSummary(a, b, false); g != expected {
+		t.Fatalf("expected\n%v\nbut\n%v", expected, g)
+	}
+	if g := DiffSummary(a, a, false); g != "" {
+		t.Fatalf("expected no differences but %v", g)
+	}
+}
+
+func TestDiffSummaryColor(t *testing.T) {
+	a, _ := parseString(`{"a":1,"b":2}`)
+	b, _ := parseString(`{"b":3,"c":4}`)
+	expected := "\x1b[31m- a\x1b[0m\n\x1b[33m~ b: 2 -> 3\x1b[0m\n\x1b[32m+ c\x1b[0m\n"
+	if g := DiffSummary(a, b, true); g != expected {
+		t.Fatalf("expected %q but %q", expected, g)
+	}
+	x, _ := parseString(`[1]`)
+	if e, g := "~ .: {...} -> [...]\n", DiffSummary(a, x, false); e != g {
+		t.Fatalf("expected %q but %q", e, g)
+	}
+}
+
+func TestEqualJSON(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bmw := doc.SelectElement("cars/*[name='BMW']")
+	if ok, err := bmw.EqualJSON(`{"models":["320","X3","X5"],"name":"BMW"}`); !ok || err != nil {
+		t.Fatalf("expected equal but %v", err)
+	}
+	// Member order and number formatting do not matter.
+	if ok, err := doc.SelectElement("age").EqualJSON(`30.0`); !ok || err != nil {
+		t.Fatalf("expected equal but %v", err)
+	}
+	ok, err := bmw.EqualJSON(`{"name":"Audi","models":["320","X3","X5"]}`)
+	if ok || err == nil {
+		t.Fatal("expected a difference")
+	}
+	if !strings.Contains(err.Error(), "~ name: BMW -> Audi") {
+		t.Fatalf("expected the difference in the error but %v", err)
+	}
+	if ok, err := bmw.EqualJSON(`{`); ok || err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	parse := func(s string, opts ParserOptions) *Node {
+		doc, err := ParseWithOptions(strings.NewReader(s), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return doc
+	}
+	ordered := ParserOptions{PreserveOrder: true}
+	a := parse(`{"name":"John","cars":[{"name":"Ford","models":["Fiesta","Focus"]}],"age":30}`, ordered)
+	b := parse(`{"age":30,"cars":[{"models":["Fiesta","Focus"],"name":"Ford"}],"name":"John"}`, ordered)
+	if !a.Equal(b) || !b.Equal(a) {
+		t.Fatal("expected documents with reordered keys to be equal")
+	}
+	if a.EqualOrdered(b) {
+		t.Fatal("expected documents with reordered keys not to be equal in order")
+	}
+	if !a.EqualOrdered(a.Clone()) {
+		t.Fatal("expected a document to equal its clone")
+	}
+	// Sorted and unsorted parses of the same document.
+	if !a.Equal(parse(`{"name":"John","cars":[{"name":"Ford","models":["Fiesta","Focus"]}],"age":30}`, ParserOptions{})) {
+		t.Fatal("expected sorted and unsorted documents to be equal")
+	}
+	// Subtrees at different levels.
+	if !a.SelectElement("cars/*[1]").Equal(parse(`[{"name":"Ford","models":["Fiesta","Focus"]}]`, ParserOptions{}).FirstChild) {
+		t.Fatal("expected equal subtrees")
+	}
+
+	for _, s := range []string{
+		`{"name":"John","cars":[{"name":"Ford","models":["Focus","Fiesta"]}],"age":30}`,
+		`{"name":"John","cars":[{"name":"Ford","models":["Fiesta"]}],"age":30}`,
+		`{"name":"John","cars":[{"name":"Ford","models":["Fiesta","Focus"]}],"age":"30"}`,
+		`{"name":"John","cars":[{"name":"Ford","models":["Fiesta","Focus"]}],"age":30.0}`,
+		`{"name":"John","cars":[{"name":"Ford","models":["Fiesta","Focus"]}]}`,
+		`{"name":"John","cars":[{"name":"Ford","models":["Fiesta","Focus"]}],"age":30,"x":null}`,
+		`{"name":"John","cars":{"name":"Ford","models":["Fiesta","Focus"]},"age":30}`,
+	} {
+		if b := parse(s, ParserOptions{}); a.Equal(b) || b.Equal(a) {
+			t.Fatalf("expected %s not to be equal", s)
+		}
+	}
+	if parse(`[]`, ParserOptions{}).Equal(parse(`{}`, ParserOptions{})) {
+		t.Fatal("expected an empty array not to equal an empty object")
+	}
+
+	keep := ParserOptions{PreserveOrder: true}
+	if !parse(`{"a":1,"b":2,"a":3}`, keep).Equal(parse(`{"b":2,"a":1,"a":3}`, keep)) {
+		t.Fatal("expected repeated keys in the same order to be equal")
+	}
+	if parse(`{"a":1,"b":2,"a":3}`, keep).Equal(parse(`{"b":2,"a":3,"a":1}`, keep)) {
+		t.Fatal("expected repeated keys in a different order not to be equal")
+	}
+}
@@ -0,0 +1,427 @@
+package jsonquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// scalar returns the text node holding the value of n if n is a text
+// node or an element whose value is a scalar.
+func scalar(n *Node) *Node {
+	if n.Type == TextNode {
+		return n
+	}
+	if n.FirstChild != nil && n.FirstChild.Type == TextNode {
+		return n.FirstChild
+	}
+	return nil
+}
+
+const hex = "0123456789abcdef"
+
+// writeJSONString writes s to buf as a quoted JSON string.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				buf.WriteString(s[start:i])
+				buf.WriteString(`\ufffd`)
+				i += size
+				start = i
+				continue
+			}
+			i += size
+			continue
+		}
+		if c >= 0x20 && c != '"' && c != '\\' {
+			i++
+			continue
+		}
+		buf.WriteString(s[start:i])
+		switch c {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			buf.WriteString(`\u00`)
+			buf.WriteByte(hex[c>>4])
+			buf.WriteByte(hex[c&0xf])
+		}
+		i++
+		start = i
+	}
+	buf.WriteString(s[start:])
+	buf.WriteByte('"')
+}
+
+// isLiteral reports whether the Data of the number or boolean text
+// node t is valid JSON for its ValueType. Data set by hand, or by a
+// NumberFormatter, may not be.
+func isLiteral(t *Node) bool {
+	if t.ValueType == BoolValue {
+		return t.Data == "true" || t.Data == "false"
+	}
+	return isJSONNumber(t.Data)
+}
+
+// isJSONNumber reports whether s is a number in the JSON grammar.
+func isJSONNumber(s string) bool {
+	digits := func(i int) int {
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		return i
+	}
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(s) && s[i] == '0':
+		i++
+	case i < len(s) && s[i] >= '1' && s[i] <= '9':
+		i = digits(i)
+	default:
+		return false
+	}
+	if i < len(s) && s[i] == '.' {
+		j := digits(i + 1)
+		if j == i+1 {
+			return false
+		}
+		i = j
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		j := digits(i)
+		if j == i {
+			return false
+		}
+		i = j
+	}
+	return i == len(s)
+}
+
+// outputJSON writes the value of n to buf. Containers nested deeper
+// than maxDepth are replaced by a placeholder; a maxDepth less than 1
+// means no limit.
+func outputJSON(buf *bytes.Buffer, n *Node, depth, maxDepth int) {
+	if t := scalar(n); t != nil {
+		switch {
+		case (t.ValueType == NumberValue || t.ValueType == BoolValue) && isLiteral(t):
+			buf.WriteString(t.Data)
+		case t.ValueType == NullValue:
+			buf.WriteString("null")
+		default:
+			writeJSONString(buf, t.Data)
+		}
+		return
+	}
+	if n.FirstChild == nil {
+		switch n.Container {
+		case ArrayContainer:
+			buf.WriteString("[]")
+		case ObjectContainer:
+			buf.WriteString("{}")
+		default:
+			buf.WriteString("null")
+		}
+		return
+	}
+	array := n.IsArray()
+	if maxDepth > 0 && depth > maxDepth {
+		if array {
+			buf.WriteString(`"[...]"`)
+		} else {
+			buf.WriteString(`"{...}"`)
+		}
+		return
+	}
+	if array {
+		buf.WriteByte('[')
+	} else {
+		buf.WriteByte('{')
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child != n.FirstChild {
+			buf.WriteByte(',')
+		}
+		if !array {
+			writeJSONString(buf, child.Data)
+			buf.WriteByte(':')
+		}
+		outputJSON(buf, child, depth+1, maxDepth)
+	}
+	if array {
+		buf.WriteByte(']')
+	} else {
+		buf.WriteByte('}')
+	}
+}
+
+// OutputJSON prints the JSON string of n. Elements whose children have
+// keys are written as objects and elements whose children have empty
+// Data as arrays; scalars are quoted or not according to their
+// ValueType. A number or boolean whose text is not a valid JSON literal,
+// which can happen once the tree has been edited, is written as a
+// string so that the output is always valid JSON. An element without
+// children is written as [] or {} according to its Container, or as
+// null if Container is not set.
+func (n *Node) OutputJSON() string {
+	var buf bytes.Buffer
+	outputJSON(&buf, n, 1, 0)
+	return buf.String()
+}
+
+// OutputJSONIndent is like OutputJSON but formats the output like
+// json.MarshalIndent: each element of an object or array begins on a
+// new line starting with prefix followed by one or more copies of
+// indent according to the nesting. Empty objects and arrays stay on
+// one line as {} and [].
+func (n *Node) OutputJSONIndent(prefix, indent string) string {
+	var buf, out bytes.Buffer
+	outputJSON(&buf, n, 1, 0)
+	if err := json.Indent(&out, buf.Bytes(), prefix, indent); err != nil {
+		// outputJSON only writes valid JSON.
+		panic("jsonquery: invalid JSON output: " + err.Error())
+	}
+	return out.String()
+}
+
+// OutputJSONDepth prints the JSON string of n, serializing at most
+// maxDepth levels of nested objects and arrays, where the value of n
+// itself is at level 1. An object or array nested deeper is replaced
+// by the string "{...}" or "[...]" respectively, so the output is
+// always valid JSON. A maxDepth less than 1 means no limit.
+func (n *Node) OutputJSONDepth(maxDepth int) string {
+	var buf bytes.Buffer
+	outputJSON(&buf, n, 1, maxDepth)
+	return buf.String()
+}
+
+// OutputJSONLines prints n in the JSON Lines format. If n is an array,
+// each of its elements is written as a JSON value on its own line;
+// otherwise the value of n is written as a single line. Every line,
+// including the last, ends with a newline.
+func (n *Node) OutputJSONLines() string {
+	var buf bytes.Buffer
+	if scalar(n) == nil && n.IsArray() {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			outputJSON(&buf, child, 1, 0)
+			buf.WriteByte('\n')
+		}
+	} else {
+		outputJSON(&buf, n, 1, 0)
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// outputTypedJSON writes the value of n to buf in the envelope format
+// described by OutputTypedJSON.
+func outputTypedJSON(buf *bytes.Buffer, n *Node) {
+	buf.WriteString(`{"type":`)
+	if t := scalar(n); t != nil {
+		switch {
+		case t.ValueType == NumberValue && isLiteral(t):
+			buf.WriteString(`"number","value":`)
+			buf.WriteString(t.Data)
+		case t.ValueType == BoolValue && isLiteral(t):
+			buf.WriteString(`"boolean","value":`)
+			buf.WriteString(t.Data)
+		case t.ValueType == NullValue:
+			buf.WriteString(`"null","value":null`)
+		default:
+			buf.WriteString(`"string","value":`)
+			writeJSONString(buf, t.Data)
+		}
+		buf.WriteByte('}')
+		return
+	}
+	if n.FirstChild == nil && n.Container == NoContainer {
+		buf.WriteString(`"null","value":null}`)
+		return
+	}
+	array := n.IsArray()
+	if array {
+		buf.WriteString(`"array","value":[`)
+	} else {
+		buf.WriteString(`"object","value":{`)
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child != n.FirstChild {
+			buf.WriteByte(',')
+		}
+		if !array {
+			writeJSONString(buf, child.Data)
+			buf.WriteByte(':')
+		}
+		outputTypedJSON(buf, child)
+	}
+	if array {
+		buf.WriteString("]}")
+	} else {
+		buf.WriteString("}}")
+	}
+}
+
+// OutputTypedJSON prints the value of n as JSON in which every value
+// is wrapped in an envelope recording its JSON type:
+//
+//	{"type":"string","value":"John"}
+//	{"type":"number","value":30}
+//	{"type":"boolean","value":true}
+//	{"type":"null","value":null}
+//	{"type":"array","value":[<envelope>,...]}
+//	{"type":"object","value":{"key":<envelope>,...}}
+//
+// ParseTypedJSON converts the output back into a node tree. As with
+// OutputJSON, a number or boolean whose text is not a valid literal is
+// written as a string.
+func (n *Node) OutputTypedJSON() string {
+	var buf bytes.Buffer
+	outputTypedJSON(&buf, n)
+	return buf.String()
+}
+
+// outputDebugJSON writes n to buf in the format described by
+// OutputDebugJSON.
+func outputDebugJSON(buf *bytes.Buffer, n *Node) {
+	switch n.Type {
+	case DocumentNode:
+		buf.WriteString(`{"type":"document"`)
+	case ElementNode:
+		buf.WriteString(`{"type":"element","data":`)
+		writeJSONString(buf, n.Data)
+	case TextNode:
+		buf.WriteString(`{"type":"text","data":`)
+		writeJSONString(buf, n.Data)
+		buf.WriteByte('}')
+		return
+	}
+	buf.WriteString(`,"children":[`)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child != n.FirstChild {
+			buf.WriteByte(',')
+		}
+		outputDebugJSON(buf, child)
+	}
+	buf.WriteString("]}")
+}
+
+// OutputDebugJSON prints the node tree rooted at n as JSON that mirrors
+// the node model rather than the data it holds:
+//
+//	{"type":"document","children":[...]}
+//	{"type":"element","data":"name","children":[...]}
+//	{"type":"text","data":"John"}
+//
+// It is meant for inspecting how a document was parsed, for example
+// to find out why a query does not match.
+func (n *Node) OutputDebugJSON() string {
+	var buf bytes.Buffer
+	outputDebugJSON(&buf, n)
+	return buf.String()
+}
+
+// ParseTypedJSON parses a document in the envelope format produced by
+// OutputTypedJSON. Object members are sorted by key, as with Parse.
+func ParseTypedJSON(r io.Reader) (*Node, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	doc := &Node{Type: DocumentNode}
+	if err := parseTypedValue(v, doc, 1); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func parseTypedValue(v interface{}, top *Node, level int) error {
+	env, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("jsonquery: expected a typed value envelope but found %T", v)
+	}
+	typ, _ := env["type"].(string)
+	value := env["value"]
+	text := func(s string, vt ValueType) {
+		appendChild(top, &Node{Type: TextNode, Data: s, ValueType: vt, level: level})
+	}
+	switch typ {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("jsonquery: invalid string value %v", value)
+		}
+		text(s, StringValue)
+	case "number":
+		num, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("jsonquery: invalid number value %v", value)
+		}
+		text(num.String(), NumberValue)
+	case "boolean":
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("jsonquery: invalid boolean value %v", value)
+		}
+		text(strconv.FormatBool(b), BoolValue)
+	case "null":
+		text("", NullValue)
+	case "array":
+		a, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("jsonquery: invalid array value %v", value)
+		}
+		top.Container = ArrayContainer
+		for _, vv := range a {
+			n := &Node{Type: ElementNode, level: level}
+			appendChild(top, n)
+			if err := parseTypedValue(vv, n, level+1); err != nil {
+				return err
+			}
+		}
+	case "object":
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("jsonquery: invalid object value %v", value)
+		}
+		top.Container = ObjectContainer
+		var keys []string
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			n := &Node{Type: ElementNode, Data: key, level: level}
+			appendChild(top, n)
+			if err := parseTypedValue(m[key], n, level+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("jsonquery: unknown value type %q", typ)
+	}
+	return nil
+}
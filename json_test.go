@@ -0,0 +1,318 @@
+package jsonquery
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOutputJSON(t *testing.T) {
+	for _, tc := range []struct {
+		s, e string
+	}{
+		{`{"x":""}`, `{"x":""}`},
+		{`{"age":30,"cars":[{"models":["Fiesta",1.50],"name":"Ford"}],"motorist":true,"name":"Jo\"hn","spouse":null}`,
+			`{"age":30,"cars":[{"models":["Fiesta",1.50],"name":"Ford"}],"motorist":true,"name":"Jo\"hn","spouse":null}`},
+		{`{"b":"x","a":"y"}`, `{"a":"y","b":"x"}`},
+		{`"top"`, `"top"`},
+		{`[[1,2],[],"a\u0001<"]`, `[[1,2],[],"a\u0001<"]`},
+		{`{"a":[],"b":{},"c":[{}]}`, `{"a":[],"b":{},"c":[{}]}`},
+		{`[]`, `[]`},
+		{`{}`, `{}`},
+	} {
+		doc, err := parseString(tc.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g := doc.OutputJSON(); tc.e != g {
+			t.Fatalf("expected %v but %v", tc.e, g)
+		}
+	}
+}
+
+func TestOutputJSONInvalidLiterals(t *testing.T) {
+	doc, err := ParseWithOptions(strings.NewReader(`{"age":30,"big":1000,"motorist":true,"n":[1.5,-2e-3]}`), ParserOptions{
+		NumberFormatter: func(v json.Number) string {
+			if v == "1000" {
+				return "1,000"
+			}
+			return v.String()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := NumberValue, doc.SelectElement("big/text()").ValueType; e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	doc.SelectElement("age/text()").SetData("thirty")
+	doc.SelectElement("motorist/text()").SetData("TRUE")
+	e := `{"age":"thirty","big":"1,000","motorist":"TRUE","n":[1.5,-2e-3]}`
+	if g := doc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	e = "{\n  \"age\": \"thirty\",\n  \"big\": \"1,000\",\n  \"motorist\": \"TRUE\",\n  \"n\": [\n    1.5,\n    -2e-3\n  ]\n}"
+	if g := doc.OutputJSONIndent("", "  "); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	e = `{"type":"object","value":{` +
+		`"age":{"type":"string","value":"thirty"},` +
+		`"big":{"type":"string","value":"1,000"},` +
+		`"motorist":{"type":"string","value":"TRUE"},` +
+		`"n":{"type":"array","value":[{"type":"number","value":1.5},{"type":"number","value":-2e-3}]}}}`
+	if g := doc.OutputTypedJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	for s, e := range map[string]bool{
+		"0": true, "-0": true, "12": true, "1.5": true, "1e9": true, "-1.25E+3": true, "0.0e-0": true,
+		"": false, "-": false, "01": false, "1.": false, ".5": false, "1e": false, "+1": false,
+		"0x10": false, "NaN": false, "Inf": false, "1 ": false, "1_000": false,
+	} {
+		if g := isJSONNumber(s); e != g {
+			t.Fatalf("%q: expected %v but %v", s, e, g)
+		}
+	}
+}
+
+func TestOutputJSONIndent(t *testing.T) {
+	doc, err := parseString(`{"name":"John","cars":[{"name":"Ford"}],"age":30}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := `{
+>  "age": 30,
+>  "cars": [
+>    {
+>      "name": "Ford"
+>    }
+>  ],
+>  "name": "John"
+>}`
+	if g := doc.OutputJSONIndent(">", "  "); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := `"John"`, doc.SelectElement("name").OutputJSONIndent("", "\t"); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	// Without sorting, the output matches json.Indent of the input.
+	for _, s := range []string{
+		testJSON,
+		`{"empty":{},"list":[],"nested":[[],[{}],{"a":[1,{"b":null}]}],"s":"a\"b\u0001<"}`,
+		`[]`,
+		`"top"`,
+	} {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, []byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		doc, err := ParseWithOrder(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, indent := range []struct{ prefix, indent string }{{"", "  "}, {"", "\t"}, {"// ", "    "}} {
+			var golden bytes.Buffer
+			if err := json.Indent(&golden, compact.Bytes(), indent.prefix, indent.indent); err != nil {
+				t.Fatal(err)
+			}
+			if e, g := golden.String(), doc.OutputJSONIndent(indent.prefix, indent.indent); e != g {
+				t.Fatalf("expected %v but %v", e, g)
+			}
+		}
+	}
+
+	// Sorted members match json.MarshalIndent of the decoded value.
+	var v interface{}
+	if err := json.Unmarshal([]byte(testJSON), &v); err != nil {
+		t.Fatal(err)
+	}
+	golden, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err = parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := string(golden), doc.OutputJSONIndent("", "  "); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestOutputJSONDepth(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		depth    int
+		expected string
+	}{
+		{1, `{"age":30,"cars":"[...]","motorist":true,"name":"John"}`},
+		{2, `{"age":30,"cars":["{...}","{...}","{...}"],"motorist":true,"name":"John"}`},
+		{3, `{"age":30,"cars":[{"models":"[...]","name":"Ford"},{"models":"[...]","name":"BMW"},{"models":"[...]","name":"Fiat"}],"motorist":true,"name":"John"}`},
+		{0, `{"age":30,"cars":[{"models":["Fiesta","Focus","Mustang"],"name":"Ford"},{"models":["320","X3","X5"],"name":"BMW"},{"models":["500","Panda"],"name":"Fiat"}],"motorist":true,"name":"John"}`},
+	}
+	for _, test := range tests {
+		if g := doc.OutputJSONDepth(test.depth); g != test.expected {
+			t.Fatalf("depth %v: expected %v but %v", test.depth, test.expected, g)
+		}
+	}
+	if e, g := `"Ford"`, doc.SelectElement("cars/*[1]/name").OutputJSONDepth(1); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestOutputJSONDepthEscape(t *testing.T) {
+	doc, err := parseString(`{"a\"b":"line\nbreak \\ \u0001 <tag>"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := `{"a\"b":"line\nbreak \\ \u0001 <tag>"}`, doc.OutputJSONDepth(0); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestOutputJSONLines(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"models":["Fiesta","Focus","Mustang"],"name":"Ford"}
+{"models":["320","X3","X5"],"name":"BMW"}
+{"models":["500","Panda"],"name":"Fiat"}
+`
+	if g := doc.SelectElement("cars").OutputJSONLines(); g != expected {
+		t.Fatalf("expected %v but %v", expected, g)
+	}
+	doc, err = parseString(`[1,"a",{"b":true}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "1\n\"a\"\n{\"b\":true}\n", doc.OutputJSONLines(); e != g {
+		t.Fatalf("expected %q but %q", e, g)
+	}
+	doc, err = parseString(`{"name":"John"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "{\"name\":\"John\"}\n", doc.OutputJSONLines(); e != g {
+		t.Fatalf("expected %q but %q", e, g)
+	}
+}
+
+func TestEmptyContainers(t *testing.T) {
+	doc, err := parseString(`{"a":[],"o":{},"n":null,"nested":[[],{}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, o := doc.SelectElement("a"), doc.SelectElement("o")
+	if a.Container != ArrayContainer || !a.IsArray() || a.IsObject() {
+		t.Fatalf("expected an array but %v", a)
+	}
+	if o.Container != ObjectContainer || o.IsArray() || !o.IsObject() {
+		t.Fatalf("expected an object but %v", o)
+	}
+	if e, g := `{"a":[],"n":null,"nested":[[],{}],"o":{}}`, doc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	typed := doc.OutputTypedJSON()
+	e := `{"type":"object","value":{` +
+		`"a":{"type":"array","value":[]},` +
+		`"n":{"type":"null","value":null},` +
+		`"nested":{"type":"array","value":[{"type":"array","value":[]},{"type":"object","value":{}}]},` +
+		`"o":{"type":"object","value":{}}}}`
+	if typed != e {
+		t.Fatalf("expected %v but %v", e, typed)
+	}
+	back, err := ParseTypedJSON(strings.NewReader(typed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !back.EqualOrdered(doc) {
+		t.Fatalf("expected %v but %v", doc.OutputJSON(), back.OutputJSON())
+	}
+
+	// A childless element built by hand has no Container and is null.
+	n := &Node{Type: ElementNode, Data: "x"}
+	if e, g := "null", n.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := `{"type":"null","value":null}`, n.OutputTypedJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	other, err := parseString(`{"a":{},"o":{},"n":[],"nested":[[1],{}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = "~ a: [] -> {}\n~ n: null -> []\n+ nested[0][0]\n"
+	if g := DiffSummary(doc, other, false); e != g {
+		t.Fatalf("expected %q but %q", e, g)
+	}
+	if g := DiffSummary(doc, doc.Clone(), false); g != "" {
+		t.Fatalf("expected no differences but %q", g)
+	}
+}
+
+func TestOutputTypedJSON(t *testing.T) {
+	doc, err := parseString(`{"name":"John","age":30,"motorist":true,"car":null,"models":["X3",5]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"object","value":{` +
+		`"age":{"type":"number","value":30},` +
+		`"car":{"type":"null","value":null},` +
+		`"models":{"type":"array","value":[{"type":"string","value":"X3"},{"type":"number","value":5}]},` +
+		`"motorist":{"type":"boolean","value":true},` +
+		`"name":{"type":"string","value":"John"}}}`
+	typed := doc.OutputTypedJSON()
+	if typed != expected {
+		t.Fatalf("expected %v but %v", expected, typed)
+	}
+	back, err := ParseTypedJSON(strings.NewReader(typed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := doc.OutputJSONDepth(0), back.OutputJSONDepth(0); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if g := back.SelectElement("age/text()").ValueType; g != NumberValue {
+		t.Fatalf("expected a number but %v", g)
+	}
+	if g := back.SelectElement("models/*[1]/text()").ValueType; g != StringValue {
+		t.Fatalf("expected a string but %v", g)
+	}
+}
+
+func TestParseTypedJSONErrors(t *testing.T) {
+	for _, s := range []string{
+		`{"name":"John"}`,
+		`[1]`,
+		`{"type":"date","value":"2020-01-01"}`,
+		`{"type":"number","value":"30"}`,
+		`{"type":"array","value":[1]}`,
+		`{"type":"object","value":{"a":{"type":"boolean","value":"yes"}}}`,
+	} {
+		if _, err := ParseTypedJSON(strings.NewReader(s)); err == nil {
+			t.Fatalf("expected an error for %v", s)
+		}
+	}
+}
+
+func TestOutputDebugJSON(t *testing.T) {
+	doc, err := parseString(`{"name":"John","tags":["a"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := `{"type":"document","children":[` +
+		`{"type":"element","data":"name","children":[{"type":"text","data":"John"}]},` +
+		`{"type":"element","data":"tags","children":[` +
+		`{"type":"element","data":"","children":[{"type":"text","data":"a"}]}]}]}`
+	if g := doc.OutputDebugJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
@@ -0,0 +1,154 @@
+package jsonquery
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// LoadURL loads the JSON document from the specified URL.
+func LoadURL(url string) (*Node, error) {
+	return LoadURLContext(context.Background(), url)
+}
+
+// LoadURLContext is like LoadURL but the request is bound to ctx, so
+// that cancelling ctx or reaching its deadline aborts it.
+func LoadURLContext(ctx context.Context, url string) (*Node, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return LoadURLWithClient(http.DefaultClient, req)
+}
+
+// LoadURLWithClient sends req with client and loads the JSON document
+// from the response as LoadResponse does, so that callers can set
+// headers, timeouts or their own transport. A nil client means
+// http.DefaultClient.
+func LoadURLWithClient(client *http.Client, req *http.Request) (*Node, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return LoadResponse(resp)
+}
+
+// LoadFile loads the JSON document from the file at path. Errors,
+// including parse errors, mention the path; the underlying error can be
+// retrieved with errors.Unwrap.
+func LoadFile(path string) (*Node, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	doc, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return doc, nil
+}
+
+// LoadReader parses the JSON document read from rc and closes rc.
+func LoadReader(rc io.ReadCloser) (*Node, error) {
+	defer rc.Close()
+	return Parse(rc)
+}
+
+// maxErrorBody is the number of bytes of the body of a failed response
+// included in the error returned by LoadResponse.
+const maxErrorBody = 128
+
+// LoadResponse parses the body of resp as a JSON document and closes
+// the body. A body with a gzip or deflate Content-Encoding is
+// decompressed first. It lets callers that fetch documents with their own
+// transport share the checks LoadURL makes. The response is rejected
+// if its status is not 2xx, in which case the error includes the start
+// of the body, if its Content-Type is set to something other than a
+// JSON type (application/json or a +json suffix such as
+// application/geo+json), text/plain or application/octet-stream, or if
+// it declares a charset other than UTF-8.
+func LoadResponse(resp *http.Response) (*Node, error) {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		msg := "jsonquery: unexpected status " + resp.Status
+		if resp.Request != nil {
+			msg = fmt.Sprintf("jsonquery: %s %s: unexpected status %s", resp.Request.Method, resp.Request.URL, resp.Status)
+		}
+		return nil, fmt.Errorf("%s: %q", msg, b)
+	}
+	if err := checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	defer resp.Body.Close()
+	r, err := decodeBody(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, err
+	}
+	return Parse(r)
+}
+
+// decodeBody returns a reader that decompresses body according to the
+// Content-Encoding encoding. The HTTP client already decompresses gzip
+// responses and removes the header when it asked for compression
+// itself, so this only applies when the caller did.
+func decodeBody(body io.Reader, encoding string) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("jsonquery: invalid gzip body: %w", err)
+		}
+		return r, nil
+	case "deflate":
+		// The deflate encoding is meant to be zlib-wrapped, but some
+		// servers send a raw deflate stream.
+		br := bufio.NewReader(body)
+		if b, err := br.Peek(2); err == nil && b[0]&0x0f == 8 && (uint(b[0])<<8|uint(b[1]))%31 == 0 {
+			r, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("jsonquery: invalid deflate body: %w", err)
+			}
+			return r, nil
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, fmt.Errorf("jsonquery: unsupported Content-Encoding %q", encoding)
+}
+
+// checkContentType reports an error if ct is not a media type that
+// may hold a UTF-8 encoded JSON document.
+func checkContentType(ct string) error {
+	if ct == "" {
+		return nil
+	}
+	mt, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return fmt.Errorf("jsonquery: invalid Content-Type %q: %v", ct, err)
+	}
+	if cs := params["charset"]; cs != "" && !strings.EqualFold(cs, "utf-8") && !strings.EqualFold(cs, "us-ascii") {
+		return fmt.Errorf("jsonquery: unsupported charset %q", cs)
+	}
+	switch {
+	case mt == "application/json", strings.HasSuffix(mt, "+json"),
+		mt == "text/plain", mt == "application/octet-stream":
+		return nil
+	}
+	return fmt.Errorf("jsonquery: unexpected Content-Type %q, expected JSON", mt)
+}
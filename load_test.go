@@ -0,0 +1,270 @@
+package jsonquery
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadURLSuccess(t *testing.T) {
+	contentTypes := []string{
+		"application/json",
+		"application/geo+json",
+	}
+
+	for _, contentType := range contentTypes {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(testJSON))
+		}))
+		defer server.Close()
+		_, err := LoadURL(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadURLContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := LoadURLContext(ctx, server.URL)
+		errc <- err
+	}()
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled but %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not aborted")
+	}
+}
+
+func TestLoadURLWithClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(testJSON))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadURLWithClient(client, req); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected a status error but %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	doc, err := LoadURLWithClient(client, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "John", doc.SelectElement("name").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestLoadURLStatus(t *testing.T) {
+	page := "<html><body>Not Found</body></html>" + strings.Repeat(" ", 200) + "END"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(page))
+		case "/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(page))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	_, err := LoadURL(server.URL + "/missing")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, s := range []string{"404 Not Found", "/missing", "<html><body>Not Found"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("expected %q in the error but %v", s, err)
+		}
+	}
+	if strings.Contains(err.Error(), "END") {
+		t.Fatalf("expected the body to be truncated but %v", err)
+	}
+	if _, err := LoadURL(server.URL + "/html"); err == nil || !strings.Contains(err.Error(), "text/html") {
+		t.Fatalf("expected a Content-Type error but %v", err)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonquery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	good, bad := filepath.Join(dir, "good.json"), filepath.Join(dir, "bad.json")
+	if err := ioutil.WriteFile(good, []byte(testJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(bad, []byte(`{"a":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := LoadFile(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "John", doc.SelectElement("name").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if _, err := LoadFile(bad); err == nil || !strings.Contains(err.Error(), bad) {
+		t.Fatalf("expected an error mentioning %v but %v", bad, err)
+	}
+	missing := filepath.Join(dir, "missing.json")
+	_, err = LoadFile(missing)
+	if !os.IsNotExist(err) || !strings.Contains(err.Error(), missing) {
+		t.Fatalf("expected a not-exist error mentioning %v but %v", missing, err)
+	}
+}
+
+func TestLoadURLContentEncoding(t *testing.T) {
+	var gz, zl, raw bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(testJSON))
+	w.Close()
+	z := zlib.NewWriter(&zl)
+	z.Write([]byte(testJSON))
+	z.Close()
+	f, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	f.Write([]byte(testJSON))
+	f.Close()
+	bodies := map[string][]byte{"gzip": gz.Bytes(), "deflate": zl.Bytes(), "raw": raw.Bytes(), "br": []byte("?")}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.URL.Path[1:]
+		w.Header().Set("Content-Type", "application/json")
+		if encoding == "raw" {
+			w.Header().Set("Content-Encoding", "deflate")
+		} else {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		w.Write(bodies[encoding])
+	}))
+	defer server.Close()
+
+	for _, encoding := range []string{"gzip", "deflate", "raw"} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/"+encoding, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Setting Accept-Encoding stops the transport from decompressing.
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		doc, err := LoadURLWithClient(nil, req)
+		if err != nil {
+			t.Fatalf("%v: %v", encoding, err)
+		}
+		if e, g := "John", doc.SelectElement("name").InnerText(); e != g {
+			t.Fatalf("%v: expected %v but %v", encoding, e, g)
+		}
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/br", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	if _, err := LoadURLWithClient(nil, req); err == nil || !strings.Contains(err.Error(), "Content-Encoding") {
+		t.Fatalf("expected an encoding error but %v", err)
+	}
+}
+
+// closeRecorder records whether the reader has been closed.
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestLoadReader(t *testing.T) {
+	rc := &closeRecorder{Reader: strings.NewReader(testJSON)}
+	doc, err := LoadReader(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rc.closed {
+		t.Fatal("expected the reader to be closed")
+	}
+	if e, g := "John", doc.SelectElement("name").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestLoadResponse(t *testing.T) {
+	for _, tc := range []struct {
+		contentType string
+		ok          bool
+	}{
+		{"", true},
+		{"application/json", true},
+		{"application/json; charset=UTF-8", true},
+		{"application/vnd.api+json", true},
+		{"text/plain", true},
+		{"text/html", false},
+		{"application/xml", false},
+		{"application/json; charset=ISO-8859-1", false},
+		{"application/json; charset", false},
+	} {
+		rc := &closeRecorder{Reader: strings.NewReader(testJSON)}
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       rc,
+		}
+		if tc.contentType != "" {
+			resp.Header.Set("Content-Type", tc.contentType)
+		}
+		_, err := LoadResponse(resp)
+		if tc.ok && err != nil {
+			t.Fatalf("%v: %v", tc.contentType, err)
+		}
+		if !tc.ok && err == nil {
+			t.Fatalf("%v: expected an error", tc.contentType)
+		}
+		if !rc.closed {
+			t.Fatalf("%v: expected the body to be closed", tc.contentType)
+		}
+	}
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("{"))}
+	if _, err := LoadResponse(resp); err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
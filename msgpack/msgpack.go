@@ -0,0 +1,138 @@
+// Package msgpack serializes jsonquery node trees to MessagePack.
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/antchfx/jsonquery"
+)
+
+// Marshal returns the MessagePack encoding of the tree rooted at n.
+//
+// Objects are encoded as maps and arrays as arrays. Scalars are encoded
+// according to the ValueType of their text node; numbers are encoded
+// as integers when they have no fractional part and fit in 64 bits,
+// and as float64 otherwise. Null, and an element without children, is
+// encoded as nil.
+func Marshal(n *jsonquery.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, n); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, n *jsonquery.Node) error {
+	if n.Type == jsonquery.TextNode {
+		return encodeScalar(buf, n)
+	}
+	switch {
+	case n.FirstChild == nil && n.Container == jsonquery.NoContainer:
+		buf.WriteByte(0xc0)
+	case n.FirstChild != nil && n.FirstChild.Type == jsonquery.TextNode:
+		return encodeScalar(buf, n.FirstChild)
+	case n.IsArray():
+		children := n.ChildNodes()
+		writeHeader(buf, len(children), 0x90, 0xdc, 0xdd)
+		for _, child := range children {
+			if err := encode(buf, child); err != nil {
+				return err
+			}
+		}
+	default:
+		children := n.ChildNodes()
+		writeHeader(buf, len(children), 0x80, 0xde, 0xdf)
+		for _, child := range children {
+			encodeString(buf, child.Data)
+			if err := encode(buf, child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func encodeScalar(buf *bytes.Buffer, n *jsonquery.Node) error {
+	switch n.ValueType {
+	case jsonquery.BoolValue:
+		if n.Data == "true" {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case jsonquery.NullValue:
+		buf.WriteByte(0xc0)
+	case jsonquery.NumberValue:
+		if i, err := strconv.ParseInt(n.Data, 10, 64); err == nil {
+			encodeInt(buf, i)
+		} else if u, err := strconv.ParseUint(n.Data, 10, 64); err == nil {
+			buf.WriteByte(0xcf)
+			binary.Write(buf, binary.BigEndian, u)
+		} else if f, err := strconv.ParseFloat(n.Data, 64); err == nil {
+			buf.WriteByte(0xcb)
+			binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+		} else {
+			return fmt.Errorf("msgpack: invalid number %q", n.Data)
+		}
+	default:
+		encodeString(buf, n.Data)
+	}
+	return nil
+}
+
+func encodeInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+func encodeString(buf *bytes.Buffer, s string) {
+	switch n := len(s); {
+	case n < 32:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdb)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.WriteString(s)
+}
+
+// writeHeader writes the header of an array or map with n entries,
+// using the fix, 16-bit or 32-bit form.
+func writeHeader(buf *bytes.Buffer, n int, fix, b16, b32 byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(b16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(b32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
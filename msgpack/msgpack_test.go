@@ -0,0 +1,104 @@
+package msgpack
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/antchfx/jsonquery"
+)
+
+func TestMarshal(t *testing.T) {
+	doc, err := jsonquery.Parse(strings.NewReader(`{"a":1,"b":[true,"x",-5,300],"c":1.5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0x83,
+		0xa1, 'a', 0x01,
+		0xa1, 'b', 0x94, 0xc3, 0xa1, 'x', 0xfb, 0xd1, 0x01, 0x2c,
+		0xa1, 'c', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+	}
+	if !bytes.Equal(b, expected) {
+		t.Fatalf("expected % x but % x", expected, b)
+	}
+}
+
+func TestMarshalEmpty(t *testing.T) {
+	doc, err := jsonquery.Parse(strings.NewReader(`{"a":[],"b":{},"c":[{}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0x83,
+		0xa1, 'a', 0x90,
+		0xa1, 'b', 0x80,
+		0xa1, 'c', 0x91, 0x80,
+	}
+	if !bytes.Equal(b, expected) {
+		t.Fatalf("expected % x but % x", expected, b)
+	}
+	b, err = Marshal(&jsonquery.Node{Type: jsonquery.ElementNode, Data: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := []byte{0xc0}; !bytes.Equal(b, e) {
+		t.Fatalf("expected % x but % x", e, b)
+	}
+}
+
+func TestMarshalScalar(t *testing.T) {
+	doc, err := jsonquery.Parse(strings.NewReader(`{"name":"John","motorist":false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Marshal(doc.SelectElement("name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := []byte{0xa4, 'J', 'o', 'h', 'n'}; !bytes.Equal(b, e) {
+		t.Fatalf("expected % x but % x", e, b)
+	}
+	b, err = Marshal(doc.SelectElement("motorist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := []byte{0xc2}; !bytes.Equal(b, e) {
+		t.Fatalf("expected % x but % x", e, b)
+	}
+	doc, err = jsonquery.Parse(strings.NewReader(`[null]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := []byte{0x91, 0xc0}; !bytes.Equal(b, e) {
+		t.Fatalf("expected % x but % x", e, b)
+	}
+}
+
+func TestMarshalLongString(t *testing.T) {
+	s := strings.Repeat("x", 300)
+	doc, err := jsonquery.Parse(strings.NewReader(`["` + s + `"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]byte{0x91, 0xda, 0x01, 0x2c}, s...)
+	if !bytes.Equal(b, expected) {
+		t.Fatalf("unexpected encoding % x", b[:8])
+	}
+}
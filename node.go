@@ -2,12 +2,16 @@ package jsonquery
 
 import (
 	"bytes"
-	"encoding/json"
-	"io"
-	"io/ioutil"
-	"net/http"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
 	"sort"
 	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/antchfx/xpath"
 )
@@ -25,6 +29,48 @@ const (
 	TextNode
 )
 
+// A ValueType is the JSON type of the value held by a text node.
+type ValueType uint
+
+const (
+	// StringValue is a JSON string.
+	StringValue ValueType = iota
+	// NumberValue is a JSON number.
+	NumberValue
+	// BoolValue is a JSON boolean.
+	BoolValue
+	// NullValue is the JSON null. Its text node has empty Data.
+	NullValue
+)
+
+// String returns the name of the JSON type, such as "number".
+func (t ValueType) String() string {
+	switch t {
+	case StringValue:
+		return "string"
+	case NumberValue:
+		return "number"
+	case BoolValue:
+		return "boolean"
+	case NullValue:
+		return "null"
+	}
+	return "ValueType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// A ContainerType is the kind of JSON container an element holds.
+type ContainerType uint
+
+const (
+	// NoContainer is the ContainerType of nodes that do not hold an
+	// object or array, and of nodes built without setting it.
+	NoContainer ContainerType = iota
+	// ObjectContainer is the ContainerType of a JSON object.
+	ObjectContainer
+	// ArrayContainer is the ContainerType of a JSON array.
+	ArrayContainer
+)
+
 // A Node consists of a NodeType and some Data (tag name for
 // element nodes, content for text) and are part of a tree of Nodes.
 type Node struct {
@@ -33,7 +79,45 @@ type Node struct {
 	Type NodeType
 	Data string
 
+	// ValueType is the JSON type of the value of a text node.
+	ValueType ValueType
+
+	// Container records whether an element or document node holds an
+	// object or an array, which tells the two apart when they are
+	// empty. The parsers of this package set it.
+	Container ContainerType
+
 	level int
+	keys  map[string]*Node
+}
+
+// Level returns the depth of n in its tree. The document node is at
+// level 0, the values at the top of the document at level 1, and every
+// other node is one level below its parent, whether it is an element
+// or a text node. Nodes added with the methods of Node are given the
+// level of their new position.
+func (n *Node) Level() int {
+	return n.level
+}
+
+// IsArray reports whether n holds a JSON array. If n.Container is not
+// set, n is taken to hold an array if its first child is an element
+// without a key.
+func (n *Node) IsArray() bool {
+	if n.Container != NoContainer {
+		return n.Container == ArrayContainer
+	}
+	return n.FirstChild != nil && n.FirstChild.Type == ElementNode && n.FirstChild.Data == ""
+}
+
+// IsObject reports whether n holds a JSON object. If n.Container is not
+// set, n is taken to hold an object if its first child is an element
+// with a key.
+func (n *Node) IsObject() bool {
+	if n.Container != NoContainer {
+		return n.Container == ObjectContainer
+	}
+	return n.FirstChild != nil && n.FirstChild.Type == ElementNode && n.FirstChild.Data != ""
 }
 
 // ChildNodes gets all child nodes of the node.
@@ -45,68 +129,949 @@ func (n *Node) ChildNodes() []*Node {
 	return a
 }
 
-// InnerText gets the value of the node and all its child nodes.
-func (n *Node) InnerText() string {
-	var output func(*bytes.Buffer, *Node)
-	output = func(buf *bytes.Buffer, n *Node) {
-		if n.Type == TextNode {
-			buf.WriteString(n.Data)
+// Root returns the node at the top of the tree n belongs to, which is
+// the document node unless n has been detached from its document.
+func (n *Node) Root() *Node {
+	for n.Parent != nil {
+		n = n.Parent
+	}
+	return n
+}
+
+// Value returns the text node holding the scalar value of n: n itself
+// if it is a text node, or the text child of an element or document
+// node whose value is a string, number, boolean or null. It returns
+// nil if n holds an object or an array. For a document whose value is
+// a scalar, doc.Value() gives the value and its ValueType.
+func (n *Node) Value() *Node {
+	return scalar(n)
+}
+
+// Ancestors returns the ancestors of n, nearest first, that is its
+// parent, its parent's parent and so on up to and including the
+// document node.
+func (n *Node) Ancestors() []*Node {
+	var a []*Node
+	for p := n.Parent; p != nil; p = p.Parent {
+		a = append(a, p)
+	}
+	return a
+}
+
+// SelectAncestor returns the nearest ancestor of n that is an element
+// with the key name, or nil if there is none.
+func (n *Node) SelectAncestor(name string) *Node {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type == ElementNode && p.Data == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// IndexInParent returns the 0-based position of n among the children
+// of its parent, or -1 if n has no parent.
+func (n *Node) IndexInParent() int {
+	if n.Parent == nil {
+		return -1
+	}
+	i := 0
+	for prev := n.PrevSibling; prev != nil; prev = prev.PrevSibling {
+		i++
+	}
+	return i
+}
+
+// TextOfChildren returns the inner text of the children of n whose
+// 0-based index is in the range [start, end). Bounds outside the list
+// of children are clamped to it.
+func (n *Node) TextOfChildren(start, end int) []string {
+	if start < 0 {
+		start = 0
+	}
+	var texts []string
+	i := 0
+	for child := n.FirstChild; child != nil && i < end; child = child.NextSibling {
+		if i >= start {
+			texts = append(texts, child.InnerText())
+		}
+		i++
+	}
+	return texts
+}
+
+// EachChild calls fn for each child node of n together with its
+// 0-based index. Iteration stops when fn returns false.
+func (n *Node) EachChild(fn func(i int, child *Node) bool) {
+	i := 0
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if !fn(i, child) {
+			return
+		}
+		i++
+	}
+}
+
+// ReverseChildren reverses the order of the child nodes of n in place.
+func (n *Node) ReverseChildren() {
+	for child := n.FirstChild; child != nil; child = child.PrevSibling {
+		child.PrevSibling, child.NextSibling = child.NextSibling, child.PrevSibling
+	}
+	n.FirstChild, n.LastChild = n.LastChild, n.FirstChild
+}
+
+// TrimChildren removes the leading and trailing children of n that are
+// empty according to IsEmpty, such as the empty strings and nulls that
+// pad the array ["", "a", "", "b", null]. Empty children between
+// non-empty ones are kept, so that array becomes ["a", "", "b"].
+func (n *Node) TrimChildren() {
+	first, last := n.FirstChild, n.LastChild
+	for first != nil && first.IsEmpty() {
+		first = first.NextSibling
+	}
+	if first == nil {
+		last = nil
+	}
+	for last != nil && last.IsEmpty() {
+		last = last.PrevSibling
+	}
+	if first == n.FirstChild && last == n.LastChild {
+		return
+	}
+	n.keys = nil
+	for child := n.FirstChild; child != first; {
+		next := child.NextSibling
+		child.Parent, child.PrevSibling, child.NextSibling = nil, nil, nil
+		child = next
+	}
+	for child := n.LastChild; child != last; {
+		prev := child.PrevSibling
+		child.Parent, child.PrevSibling, child.NextSibling = nil, nil, nil
+		child = prev
+	}
+	if first != nil {
+		first.PrevSibling = nil
+		last.NextSibling = nil
+	}
+	n.FirstChild, n.LastChild = first, last
+}
+
+// SortChildren sorts the child nodes of n in place using less. The
+// sort is stable, so children that compare equal keep their order.
+func (n *Node) SortChildren(less func(a, b *Node) bool) {
+	children := n.ChildNodes()
+	sort.SliceStable(children, func(i, j int) bool {
+		return less(children[i], children[j])
+	})
+	n.setChildren(children)
+}
+
+// setChildren replaces the child list of n with children, relinking
+// their sibling pointers in the given order.
+func (n *Node) setChildren(children []*Node) {
+	n.FirstChild, n.LastChild = nil, nil
+	for i, child := range children {
+		child.Parent = n
+		child.PrevSibling, child.NextSibling = nil, nil
+		if i > 0 {
+			child.PrevSibling = children[i-1]
+			children[i-1].NextSibling = child
+		}
+	}
+	if len(children) > 0 {
+		n.FirstChild = children[0]
+		n.LastChild = children[len(children)-1]
+	}
+}
+
+// AddChild adds child as the last child of n. If child already has a
+// parent, it is removed from it first, so AddChild can also move a node
+// within a tree or to the end of its parent's children. It panics if
+// child is n or one of its ancestors, which would make the tree a
+// cycle.
+func (n *Node) AddChild(child *Node) {
+	for p := n; p != nil; p = p.Parent {
+		if p == child {
+			panic("jsonquery: AddChild: child is the node or one of its ancestors")
+		}
+	}
+	if child.Parent != nil {
+		child.Parent.RemoveChild(child)
+	}
+	n.keys = nil
+	shiftLevel(child, n.level+1-child.level)
+	appendChild(n, child)
+}
+
+// RemoveChild removes child from the children of n and detaches it,
+// leaving it without parent or siblings. It does nothing if child is
+// not a child of n.
+func (n *Node) RemoveChild(child *Node) {
+	if child.Parent != n {
+		return
+	}
+	n.keys = nil
+	if child.PrevSibling != nil {
+		child.PrevSibling.NextSibling = child.NextSibling
+	} else {
+		n.FirstChild = child.NextSibling
+	}
+	if child.NextSibling != nil {
+		child.NextSibling.PrevSibling = child.PrevSibling
+	} else {
+		n.LastChild = child.PrevSibling
+	}
+	child.Parent, child.PrevSibling, child.NextSibling = nil, nil, nil
+}
+
+// SetData sets the Data of n, which is the key of an object member or
+// the text of a text node. Use it rather than assigning Data directly
+// to keep the key index of the parent, if any, up to date. Setting the
+// text of a null makes it a string; other text nodes keep their
+// ValueType.
+func (n *Node) SetData(s string) {
+	n.Data = s
+	if n.Type == TextNode && n.ValueType == NullValue {
+		n.ValueType = StringValue
+	}
+	if n.Parent != nil {
+		n.Parent.keys = nil
+	}
+}
+
+// InsertBefore inserts newNode into the tree as the previous sibling
+// of n. newNode must not already be part of a tree.
+func (n *Node) InsertBefore(newNode *Node) {
+	if n.Parent != nil {
+		n.Parent.keys = nil
+	}
+	shiftLevel(newNode, n.level-newNode.level)
+	newNode.Parent = n.Parent
+	newNode.PrevSibling = n.PrevSibling
+	newNode.NextSibling = n
+	if n.PrevSibling != nil {
+		n.PrevSibling.NextSibling = newNode
+	} else if n.Parent != nil {
+		n.Parent.FirstChild = newNode
+	}
+	n.PrevSibling = newNode
+}
+
+// InsertAfter inserts newNode into the tree as the next sibling of n.
+// newNode must not already be part of a tree.
+func (n *Node) InsertAfter(newNode *Node) {
+	if n.Parent != nil {
+		n.Parent.keys = nil
+	}
+	shiftLevel(newNode, n.level-newNode.level)
+	newNode.Parent = n.Parent
+	newNode.PrevSibling = n
+	newNode.NextSibling = n.NextSibling
+	if n.NextSibling != nil {
+		n.NextSibling.PrevSibling = newNode
+	} else if n.Parent != nil {
+		n.Parent.LastChild = newNode
+	}
+	n.NextSibling = newNode
+}
+
+// Splice changes the children of n like JavaScript's Array.splice: it
+// removes deleteCount children starting at index start, inserts
+// newChildren in their place and returns the removed nodes. A negative
+// start counts back from the end of the children. start and
+// deleteCount are clamped to the existing children, so Splice never
+// fails. newChildren must not already be part of a tree.
+func (n *Node) Splice(start, deleteCount int, newChildren ...*Node) []*Node {
+	children := n.ChildNodes()
+	if start < 0 {
+		start += len(children)
+		if start < 0 {
+			start = 0
+		}
+	}
+	if start > len(children) {
+		start = len(children)
+	}
+	if deleteCount < 0 {
+		deleteCount = 0
+	}
+	if deleteCount > len(children)-start {
+		deleteCount = len(children) - start
+	}
+	removed := append([]*Node(nil), children[start:start+deleteCount]...)
+	for _, child := range removed {
+		child.Parent, child.PrevSibling, child.NextSibling = nil, nil, nil
+	}
+	for _, child := range newChildren {
+		shiftLevel(child, n.level+1-child.level)
+	}
+	result := make([]*Node, 0, len(children)-deleteCount+len(newChildren))
+	result = append(result, children[:start]...)
+	result = append(result, newChildren...)
+	result = append(result, children[start+deleteCount:]...)
+	n.setChildren(result)
+	n.keys = nil
+	if len(removed) == 0 {
+		return nil
+	}
+	return removed
+}
+
+// ReplaceText sets the text value of n. If n is a text node its Data
+// is replaced; if n is an element, the Data of its single text child is
+// replaced, or a string text child is added if it has none. As with
+// SetData, a replaced null becomes a string and other text nodes keep
+// their ValueType, so port.ReplaceText("8443") keeps port a number;
+// text that is no longer a valid number or boolean is written as a JSON
+// string. It returns an error if n has element children, since the text
+// to replace would be ambiguous.
+func (n *Node) ReplaceText(newText string) error {
+	if n.Type == TextNode {
+		n.SetData(newText)
+		return nil
+	}
+	switch {
+	case n.FirstChild == nil:
+		t := &Node{Type: TextNode, Data: newText, ValueType: StringValue, Parent: n, level: n.level + 1}
+		n.FirstChild = t
+		n.LastChild = t
+		n.Container = NoContainer
+	case n.FirstChild == n.LastChild && n.FirstChild.Type == TextNode:
+		n.FirstChild.SetData(newText)
+	default:
+		return errors.New("jsonquery: cannot replace the text of a node with element children")
+	}
+	return nil
+}
+
+// ReplaceAllText sets the Data of every text node in the tree rooted
+// at n that is exactly equal to old to new, and returns the number of
+// nodes changed. As with ReplaceText, a changed null becomes a string
+// and other text nodes keep their ValueType.
+func (n *Node) ReplaceAllText(old, new string) int {
+	count := 0
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if n.Type == TextNode && n.Data == old {
+			n.SetData(new)
+			count++
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return count
+}
+
+// ReplaceAllTextRegexp replaces the matches of re in every text node in
+// the tree rooted at n with repl, as regexp.ReplaceAllString does, and
+// returns the number of nodes changed. As with ReplaceAllText, a
+// changed null becomes a string and other text nodes keep their
+// ValueType.
+func (n *Node) ReplaceAllTextRegexp(re *regexp.Regexp, repl string) int {
+	count := 0
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if n.Type == TextNode && re.MatchString(n.Data) {
+			n.SetData(re.ReplaceAllString(n.Data, repl))
+			count++
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return count
+}
+
+// AsDocument returns a new document holding a copy of the value of n,
+// so that queries and output treat n as the whole document: the
+// members or elements of n become the top-level values, and the text
+// of a scalar becomes the document's only child. The copy is detached
+// from the tree n belongs to, so changes made to one are not visible
+// in the other.
+func (n *Node) AsDocument() *Node {
+	if n.Type == TextNode {
+		doc := &Node{Type: DocumentNode}
+		child := cloneNode(n, 1)
+		child.Parent = doc
+		doc.FirstChild = child
+		doc.LastChild = child
+		return doc
+	}
+	doc := cloneNode(n, 0)
+	doc.Type = DocumentNode
+	doc.Data = ""
+	return doc
+}
+
+// Clone returns a copy of n and all of its descendants. The copy has
+// no parent or siblings and keeps the type and level of n, so changes
+// made to it, including with the methods of Node, are not visible in
+// the tree n belongs to, and the reverse.
+func (n *Node) Clone() *Node {
+	m := cloneNode(n, n.level)
+	m.Type = n.Type
+	return m
+}
+
+func cloneNode(n *Node, level int) *Node {
+	m := &Node{Type: n.Type, Data: n.Data, ValueType: n.ValueType, Container: n.Container, level: level}
+	if m.Type == DocumentNode {
+		m.Type = ElementNode
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c := cloneNode(child, level+1)
+		c.Parent = m
+		if m.FirstChild == nil {
+			m.FirstChild = c
+		} else {
+			m.LastChild.NextSibling = c
+			c.PrevSibling = m.LastChild
+		}
+		m.LastChild = c
+	}
+	return m
+}
+
+// Walk calls fn for n and each of its descendants in pre-order, that is
+// document order: a node is visited before its children, and a node's
+// children and their descendants before its next sibling. If fn returns
+// false, the children of that node are skipped and the walk continues
+// with its next sibling, like ast.Inspect. Use WalkWithBreak to stop
+// the walk entirely. fn must not change the tree.
+func Walk(n *Node, fn func(*Node) bool) {
+	WalkWithBreak(n, func(m *Node) (bool, bool) {
+		return fn(m), false
+	})
+}
+
+// WalkWithBreak is like Walk but fn also reports whether to stop: if it
+// returns stop true, the walk ends at once without visiting any other
+// node, whatever descend is.
+func WalkWithBreak(n *Node, fn func(*Node) (descend, stop bool)) {
+	for m := n; ; {
+		descend, stop := fn(m)
+		if stop {
+			return
+		}
+		if descend && m.FirstChild != nil {
+			m = m.FirstChild
+			continue
+		}
+		for m != n && m.NextSibling == nil {
+			m = m.Parent
+		}
+		if m == n {
 			return
 		}
+		m = m.NextSibling
+	}
+}
+
+// CommonAncestor returns the nearest node that is an ancestor of both
+// a and b. A node is considered an ancestor of itself, so if a contains
+// b then a is returned. It returns nil if a and b belong to different
+// trees.
+func CommonAncestor(a, b *Node) *Node {
+	if a == nil || b == nil {
+		return nil
+	}
+	depth := func(n *Node) int {
+		d := 0
+		for ; n.Parent != nil; n = n.Parent {
+			d++
+		}
+		return d
+	}
+	da, db := depth(a), depth(b)
+	for ; da > db; da-- {
+		a = a.Parent
+	}
+	for ; db > da; db-- {
+		b = b.Parent
+	}
+	for a != b {
+		a, b = a.Parent, b.Parent
+	}
+	return a
+}
+
+// DepthHistogram returns the number of nodes at each depth of the
+// subtree rooted at n, including n itself. Depths are counted from the
+// document root, which is at depth 0.
+func (n *Node) DepthHistogram() map[int]int {
+	m := make(map[int]int)
+	var walk func(*Node, int)
+	walk = func(n *Node, depth int) {
+		m[depth]++
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child, depth+1)
+		}
+	}
+	walk(n, n.level)
+	return m
+}
+
+// Validate checks that the tree rooted at n is well formed. It returns
+// an error if a node is reachable more than once, which means the tree
+// contains a cycle that would make traversals such as OutputXML never
+// terminate, or if the parent and sibling links of a node disagree.
+func (n *Node) Validate() error {
+	visited := map[*Node]bool{n: true}
+	var validate func(*Node) error
+	validate = func(n *Node) error {
+		var prev *Node
 		for child := n.FirstChild; child != nil; child = child.NextSibling {
-			output(buf, child)
+			if visited[child] {
+				return fmt.Errorf("jsonquery: cycle detected at node %q", child.Data)
+			}
+			visited[child] = true
+			if child.Parent != n {
+				return fmt.Errorf("jsonquery: node %q has the wrong parent", child.Data)
+			}
+			if child.PrevSibling != prev {
+				return fmt.Errorf("jsonquery: node %q has the wrong previous sibling", child.Data)
+			}
+			if err := validate(child); err != nil {
+				return err
+			}
+			prev = child
+		}
+		if n.LastChild != prev {
+			return fmt.Errorf("jsonquery: node %q has the wrong last child", n.Data)
 		}
+		return nil
 	}
+	return validate(n)
+}
+
+// InnerText gets the value of the node and all its child nodes.
+func (n *Node) InnerText() string {
 	var buf bytes.Buffer
-	output(&buf, n)
+	eachText(n, func(t *Node) {
+		buf.WriteString(t.Data)
+	})
 	return buf.String()
 }
 
-func outputXML(buf *bytes.Buffer, n *Node) {
-	switch n.Type {
-	case ElementNode:
-		if n.Data == "" {
-			buf.WriteString("<element>")
+// InnerTextLen returns the length in bytes of the string InnerText
+// would return, without building it.
+func (n *Node) InnerTextLen() int {
+	size := 0
+	eachText(n, func(t *Node) {
+		size += len(t.Data)
+	})
+	return size
+}
+
+// eachText calls fn for every text node of the subtree rooted at n, in
+// document order. It walks the tree without recursion, so the depth of
+// the tree is not limited by the size of the stack.
+func eachText(n *Node, fn func(*Node)) {
+	for m := n; ; {
+		if m.Type == TextNode {
+			fn(m)
+		} else if m.FirstChild != nil {
+			m = m.FirstChild
+			continue
+		}
+		for m != n && m.NextSibling == nil {
+			m = m.Parent
+		}
+		if m == n {
+			return
+		}
+		m = m.NextSibling
+	}
+}
+
+// XMLOptions controls how OutputXMLWithOptions converts a tree to XML.
+// The zero value produces the same output as OutputXML.
+type XMLOptions struct {
+	// Namespaces maps namespace prefixes to namespace URIs. An object
+	// key of the form "prefix:name" whose prefix is in the map is
+	// written as a namespaced element, and an xmlns:prefix declaration
+	// is added to the outermost element of each subtree that uses it.
+	// Keys with prefixes not in the map are written unchanged.
+	Namespaces map[string]string
+
+	// SortKeys writes the members of every object sorted by key. By
+	// default members are written in tree order, which is sorted
+	// unless the document was parsed with PreserveOrder. The order of
+	// array elements is never changed.
+	SortKeys bool
+
+	// KeyLess, if set, is used instead of byte-wise comparison to sort
+	// the members of objects when SortKeys is set, as
+	// ParserOptions.KeyLess is when parsing. Members whose keys compare
+	// equal keep their tree order.
+	KeyLess func(a, b string) bool
+
+	// SelfClose writes elements without content, such as empty
+	// objects, empty arrays and null, as <name/>. By default they are
+	// written as <name></name>.
+	SelfClose bool
+
+	// Prefix and Indent, if either is non-empty, put every element on
+	// its own line, starting with Prefix followed by one copy of Indent
+	// per level of nesting, like xml.MarshalIndent. An element holding
+	// a scalar keeps its text on the same line. By default the output
+	// has no line breaks.
+	Prefix, Indent string
+
+	// RawNames writes keys as element names unchanged. By default a key
+	// that is not a valid XML name is sanitized: every character that
+	// may not appear in a name is replaced with an underscore, and an
+	// underscore is prepended if the key starts with a digit, a hyphen
+	// or a period, so "first name" becomes first_name, "2nd" becomes
+	// _2nd and "@type" becomes _type. Distinct keys may therefore map
+	// to the same name. Colons are kept for namespace prefixes.
+	RawNames bool
+
+	// ArrayElementName is the element name written for the members of
+	// an array, which have no key. The default is "element".
+	ArrayElementName string
+
+	// SingularNames names the members of an array after the key of the
+	// array, made singular with simple English rules, so the members
+	// of "cars" become <car> and those of "categories" become
+	// <category>. Members of an array without a key, such as a nested
+	// array, or whose key does not end in "s" fall back to
+	// ArrayElementName.
+	SingularNames bool
+}
+
+// singular returns the singular of the plural English noun s, or ""
+// if s does not look like a plural.
+func singular(s string) string {
+	switch {
+	case len(s) > 3 && strings.HasSuffix(s, "ies"):
+		return s[:len(s)-3] + "y"
+	case strings.HasSuffix(s, "sses"), strings.HasSuffix(s, "xes"),
+		strings.HasSuffix(s, "ches"), strings.HasSuffix(s, "shes"):
+		return s[:len(s)-2]
+	case len(s) > 1 && strings.HasSuffix(s, "s") && !strings.HasSuffix(s, "ss"):
+		return s[:len(s)-1]
+	}
+	return ""
+}
+
+// xmlName returns key as a valid XML name, as described for
+// XMLOptions.RawNames.
+func xmlName(key string) string {
+	valid := true
+	for i, r := range key {
+		if !isXMLNameChar(r) || i == 0 && !isXMLNameStart(r) {
+			valid = false
+			break
+		}
+	}
+	if valid {
+		return key
+	}
+	var b strings.Builder
+	for i, r := range key {
+		if i == 0 && !isXMLNameStart(r) && isXMLNameChar(r) {
+			b.WriteByte('_')
+		}
+		if isXMLNameChar(r) {
+			b.WriteRune(r)
 		} else {
-			buf.WriteString("<" + n.Data + ">")
+			b.WriteByte('_')
 		}
-	case TextNode:
-		buf.WriteString(n.Data)
+	}
+	return b.String()
+}
+
+func isXMLNameStart(r rune) bool {
+	return r == '_' || r == ':' || unicode.IsLetter(r)
+}
+
+func isXMLNameChar(r rune) bool {
+	return isXMLNameStart(r) || r == '-' || r == '.' || unicode.IsDigit(r)
+}
+
+// xmlWriter writes a tree as XML according to XMLOptions.
+type xmlWriter struct {
+	buf      bytes.Buffer
+	opts     *XMLOptions
+	declared map[string]bool
+}
+
+// newline starts a new line for an element at the given depth if the
+// output is indented.
+func (w *xmlWriter) newline(depth int) {
+	if w.opts.Prefix == "" && w.opts.Indent == "" {
 		return
 	}
+	w.buf.WriteByte('\n')
+	w.buf.WriteString(w.opts.Prefix)
+	for i := 0; i < depth; i++ {
+		w.buf.WriteString(w.opts.Indent)
+	}
+}
 
-	for child := n.FirstChild; child != nil; child = child.NextSibling {
-		outputXML(buf, child)
+// children returns the children of n in output order.
+func (w *xmlWriter) children(n *Node) []*Node {
+	children := n.ChildNodes()
+	if w.opts.SortKeys && !n.IsArray() {
+		less := w.opts.KeyLess
+		if less == nil {
+			less = func(a, b string) bool { return a < b }
+		}
+		sort.SliceStable(children, func(i, j int) bool {
+			return less(children[i].Data, children[j].Data)
+		})
 	}
-	if n.Data == "" {
-		buf.WriteString("</element>")
-	} else {
-		buf.WriteString("</" + n.Data + ">")
+	return children
+}
+
+// xmlFrame is an element whose start tag has been written.
+type xmlFrame struct {
+	n        *Node
+	name     string
+	depth    int
+	children []*Node
+	next     int
+	// prefix is the namespace prefix declared by the element, if any.
+	prefix string
+}
+
+// output writes n and its descendants. It keeps the open elements on
+// an explicit stack rather than recursing, so the depth of the tree is
+// not limited by the size of the stack.
+func (w *xmlWriter) output(n *Node, depth int) {
+	var stack []*xmlFrame
+	if f := w.start(n, depth); f != nil {
+		stack = append(stack, f)
+	}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		if f.next < len(f.children) {
+			child := f.children[f.next]
+			f.next++
+			if c := w.start(child, f.depth+1); c != nil {
+				stack = append(stack, c)
+			}
+			continue
+		}
+		w.end(f)
+		stack = stack[:len(stack)-1]
+	}
+}
+
+// start writes a text node, a self-closed element or the start tag of
+// an element. In the last case it returns the frame of the element.
+func (w *xmlWriter) start(n *Node, depth int) *xmlFrame {
+	if n.Type == TextNode {
+		xml.EscapeText(&w.buf, []byte(n.Data))
+		return nil
+	}
+	f := &xmlFrame{n: n, name: n.Data, depth: depth}
+	if f.name == "" {
+		f.name = w.memberName(n)
+	}
+	if !w.opts.RawNames {
+		f.name = xmlName(f.name)
+	}
+	w.newline(depth)
+	w.buf.WriteString("<" + f.name)
+	if i := strings.IndexByte(f.name, ':'); i > 0 {
+		prefix := f.name[:i]
+		if uri, ok := w.opts.Namespaces[prefix]; ok && !w.declared[prefix] {
+			w.buf.WriteString(" xmlns:" + prefix + `="`)
+			xml.EscapeText(&w.buf, []byte(uri))
+			w.buf.WriteString(`"`)
+			w.declared[prefix] = true
+			f.prefix = prefix
+		}
+	}
+	if (n.FirstChild == nil || n.IsNull()) && w.opts.SelfClose {
+		w.buf.WriteString("/>")
+		delete(w.declared, f.prefix)
+		return nil
+	}
+	w.buf.WriteString(">")
+	f.children = w.children(n)
+	return f
+}
+
+// memberName returns the element name of the array member n.
+func (w *xmlWriter) memberName(n *Node) string {
+	if w.opts.SingularNames && n.Parent != nil {
+		if name := singular(n.Parent.Data); name != "" {
+			return name
+		}
+	}
+	if w.opts.ArrayElementName != "" {
+		return w.opts.ArrayElementName
+	}
+	return "element"
+}
+
+// end writes the end tag of the element of f.
+func (w *xmlWriter) end(f *xmlFrame) {
+	if f.n.FirstChild != nil && f.n.FirstChild.Type != TextNode {
+		w.newline(f.depth)
 	}
+	w.buf.WriteString("</" + f.name + ">")
+	delete(w.declared, f.prefix)
 }
 
 // OutputXML prints the XML string.
 func (n *Node) OutputXML() string {
+	return n.OutputXMLWithOptions(XMLOptions{})
+}
+
+// OutputXMLIndent is like OutputXML but puts every element on its own
+// line, starting with prefix followed by one copy of indent per level
+// of nesting, like xml.MarshalIndent. An element holding a scalar keeps
+// its text on the same line.
+func (n *Node) OutputXMLIndent(prefix, indent string) string {
+	return n.OutputXMLWithOptions(XMLOptions{Prefix: prefix, Indent: indent})
+}
+
+// OutputXMLWithOptions prints the XML string using the given options.
+func (n *Node) OutputXMLWithOptions(opts XMLOptions) string {
+	w := &xmlWriter{opts: &opts, declared: make(map[string]bool)}
+	w.buf.WriteString(`<?xml version="1.0"?>`)
+	for _, child := range w.children(n) {
+		w.output(child, 0)
+	}
+	return w.buf.String()
+}
+
+// String returns a short description of n for debugging, such as
+// Element "cars" (array) or Text "John" (string).
+func (n *Node) String() string {
+	var s string
+	switch n.Type {
+	case DocumentNode:
+		s = "Document"
+	case ElementNode:
+		s = "Element " + strconv.Quote(n.Data)
+	case TextNode:
+		return "Text " + strconv.Quote(n.Data) + " (" + n.ValueType.String() + ")"
+	default:
+		return fmt.Sprintf("NodeType(%d) %q", n.Type, n.Data)
+	}
+	switch {
+	case n.IsArray():
+		s += " (array)"
+	case n.IsObject():
+		s += " (object)"
+	}
+	return s
+}
+
+// Dump returns a description of the tree rooted at n for debugging,
+// with one node per line as returned by String, indented by two spaces
+// per level below n and followed by the level of the node.
+func (n *Node) Dump() string {
+	var buf bytes.Buffer
+	Walk(n, func(m *Node) bool {
+		for i := n.level; i < m.level; i++ {
+			buf.WriteString("  ")
+		}
+		fmt.Fprintf(&buf, "%s [level %d]\n", m, m.level)
+		return true
+	})
+	return buf.String()
+}
+
+// maxDOTLabel is the maximum number of characters of text shown in
+// a node label by OutputDOT.
+const maxDOTLabel = 20
+
+// OutputDOT prints the tree rooted at n as a Graphviz DOT graph. It
+// is meant for inspecting the structure of a document, for example
+// by piping the output to `dot -Tpng`.
+func (n *Node) OutputDOT() string {
 	var buf bytes.Buffer
-	buf.WriteString(`<?xml version="1.0"?>`)
-	for n := n.FirstChild; n != nil; n = n.NextSibling {
-		outputXML(&buf, n)
+	buf.WriteString("digraph jsonquery {\n")
+	id := 0
+	var output func(*Node) int
+	output = func(n *Node) int {
+		self := id
+		id++
+		var label, shape string
+		switch n.Type {
+		case DocumentNode:
+			label, shape = "document", "doublecircle"
+		case ElementNode:
+			label, shape = n.Data, "ellipse"
+			if label == "" {
+				label = "element"
+			}
+		case TextNode:
+			label, shape = n.Data, "box"
+			if r := []rune(label); len(r) > maxDOTLabel {
+				label = string(r[:maxDOTLabel]) + "..."
+			}
+		}
+		fmt.Fprintf(&buf, "\tn%d [label=%s, shape=%s];\n", self, strconv.Quote(label), shape)
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			fmt.Fprintf(&buf, "\tn%d -> n%d;\n", self, output(child))
+		}
+		return self
 	}
+	output(n)
+	buf.WriteString("}\n")
 	return buf.String()
 }
 
-// SelectElement like Query finds the first of child elements 
-// matching the specified query. However, it will panic if the
-// query cannot be parsed.
+// SelectElementByKey returns the child element of n whose key is key,
+// or nil if there is none. It uses the key index built when parsing
+// with ParserOptions.IndexKeys and falls back to scanning the children.
+func (n *Node) SelectElementByKey(key string) *Node {
+	if n.keys != nil {
+		return n.keys[key]
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == ElementNode && child.Data == key {
+			return child
+		}
+	}
+	return nil
+}
+
+// SelectChildrenGlob returns the child elements of n whose key matches
+// pattern, using the syntax of path.Match, for example "addr_*" or
+// "item[0-9]". Array elements, which have no key, never match. It
+// returns nil if pattern is malformed.
+func (n *Node) SelectChildrenGlob(pattern string) []*Node {
+	var nodes []*Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != ElementNode || child.Data == "" {
+			continue
+		}
+		ok, err := path.Match(pattern, child.Data)
+		if err != nil {
+			return nil
+		}
+		if ok {
+			nodes = append(nodes, child)
+		}
+	}
+	return nodes
+}
+
+// SelectElement like Query finds the first of child elements
+// matching the specified query. It returns nil if the query cannot
+// be parsed; use Query to get the error, or FindOne to panic.
 func (n *Node) SelectElement(query string) *Node {
-	return FindOne(n, query)
+	node, _ := Query(n, query)
+	return node
 }
 
 // SelectElements like QueryAll finds all child elements matching
-// the specified query. However, it will panic if the query cannot
-// be parsed.
+// the specified query. It returns nil if the query cannot be parsed;
+// use QueryAll to get the error, or Find to panic.
 func (n *Node) SelectElements(query string) []*Node {
-	return Find(n, query)
+	nodes, _ := QueryAll(n, query)
+	return nodes
 }
 
 // Queryfinds the first of child elements 
@@ -121,6 +1086,60 @@ func (n *Node) QueryAll(query string) ([]*Node, error) {
 	return QueryAll(n, query)
 }
 
+// QueryAllContextLimit is like QueryAll but stops once limit matches
+// have been found, if limit is positive, or once ctx is done. In the
+// latter case the matches found so far are returned with ctx.Err().
+func (n *Node) QueryAllContextLimit(ctx context.Context, query string, limit int) ([]*Node, error) {
+	exp, err := getQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return selectAll(ctx, n, exp, limit)
+}
+
+// ForEachMatch calls fn for every child element matching the
+// specified query, in document order, without collecting the matches
+// into a slice. It stops at and returns the first error returned by fn,
+// or the error from parsing or evaluating query. FindEach and
+// FindEachWithBreak are built on it for callers that prefer a panic.
+func (n *Node) ForEachMatch(query string, fn func(*Node) error) error {
+	exp, err := getQuery(query)
+	if err != nil {
+		return err
+	}
+	return eachMatch(n, exp, fn)
+}
+
+// Aggregate runs query and returns the minimum, maximum, sum, average
+// and number of the matched nodes, treating the inner text of every
+// match as a number. It returns an error if query cannot be parsed or
+// if any match is not a number. If nothing matches, all statistics
+// are zero.
+func (n *Node) Aggregate(query string) (min, max, sum, avg float64, count int, err error) {
+	nodes, err := QueryAll(n, query)
+	if err != nil {
+		return 0, 0, 0, 0, 0, err
+	}
+	for _, node := range nodes {
+		v, err := strconv.ParseFloat(node.InnerText(), 64)
+		if err != nil {
+			return 0, 0, 0, 0, 0, fmt.Errorf("jsonquery: %q is not a number", node.InnerText())
+		}
+		if count == 0 || v < min {
+			min = v
+		}
+		if count == 0 || v > max {
+			max = v
+		}
+		sum += v
+		count++
+	}
+	if count > 0 {
+		avg = sum / float64(count)
+	}
+	return min, max, sum, avg, count, nil
+}
+
 // QuerySelector returns the first matched child Node by the
 // specified XPath selector.
 func (n *Node) QuerySelector(selector *xpath.Expr) *Node {
@@ -133,87 +1152,40 @@ func (n *Node) QuerySelectorAll(selector *xpath.Expr) []*Node {
 	return QuerySelectorAll(n, selector)
 }
 
-// LoadURL loads the JSON document from the specified URL.
-func LoadURL(url string) (*Node, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	return Parse(resp.Body)
+// QuerySelectorExists reports whether any child Node matches the
+// specified XPath selector. It stops at the first match.
+func (n *Node) QuerySelectorExists(selector *xpath.Expr) bool {
+	return selector.Select(CreateXPathNavigator(n)).MoveNext()
 }
 
-func parseValue(x interface{}, top *Node, level int) {
-	addNode := func(n *Node) {
-		if n.level == top.level {
-			top.NextSibling = n
-			n.PrevSibling = top
-			n.Parent = top.Parent
-			if top.Parent != nil {
-				top.Parent.LastChild = n
-			}
-		} else if n.level > top.level {
-			n.Parent = top
-			if top.FirstChild == nil {
-				top.FirstChild = n
-				top.LastChild = n
-			} else {
-				t := top.LastChild
-				t.NextSibling = n
-				n.PrevSibling = t
-				top.LastChild = n
-			}
-		}
+// QuerySelectorCount returns the number of Nodes that match the
+// specified XPath selector, without collecting them.
+func (n *Node) QuerySelectorCount(selector *xpath.Expr) int {
+	count := 0
+	t := selector.Select(CreateXPathNavigator(n))
+	for t.MoveNext() {
+		count++
 	}
-	switch v := x.(type) {
-	case []interface{}:
-		for _, vv := range v {
-			n := &Node{Type: ElementNode, level: level}
-			addNode(n)
-			parseValue(vv, n, level+1)
-		}
-	case map[string]interface{}:
-		// The Go’s map iteration order is random.
-		// (https://blog.golang.org/go-maps-in-action#Iteration-order)
-		var keys []string
-		for key := range v {
-			keys = append(keys, key)
-		}
-		sort.Strings(keys)
-		for _, key := range keys {
-			n := &Node{Data: key, Type: ElementNode, level: level}
-			addNode(n)
-			parseValue(v[key], n, level+1)
-		}
-	case string:
-		n := &Node{Data: v, Type: TextNode, level: level}
-		addNode(n)
-	case float64:
-		s := strconv.FormatFloat(v, 'f', -1, 64)
-		n := &Node{Data: s, Type: TextNode, level: level}
-		addNode(n)
-	case bool:
-		s := strconv.FormatBool(v)
-		n := &Node{Data: s, Type: TextNode, level: level}
-		addNode(n)
-	}
-}
-
-func parse(b []byte) (*Node, error) {
-	var v interface{}
-	if err := json.Unmarshal(b, &v); err != nil {
-		return nil, err
+	return count
+}
+
+// QuerySelectorText returns the inner text of the first child Node
+// matched by the specified XPath selector, or an empty string if
+// nothing matches.
+func (n *Node) QuerySelectorText(selector *xpath.Expr) string {
+	if node := QuerySelector(n, selector); node != nil {
+		return node.InnerText()
 	}
-	doc := &Node{Type: DocumentNode}
-	parseValue(v, doc, 1)
-	return doc, nil
+	return ""
 }
 
-// Parse JSON document.
-func Parse(r io.Reader) (*Node, error) {
-	b, err := ioutil.ReadAll(r)
-	if err != nil {
-		return nil, err
+// QuerySelectorAllText returns the inner text of every Node that
+// matches the specified XPath selector.
+func (n *Node) QuerySelectorAllText(selector *xpath.Expr) []string {
+	var a []string
+	t := selector.Select(CreateXPathNavigator(n))
+	for t.MoveNext() {
+		a = append(a, t.Current().(*NodeNavigator).cur.InnerText())
 	}
-	return parse(b)
+	return a
 }
@@ -1,11 +1,17 @@
 package jsonquery
 
 import (
-	"net/http"
-	"net/http/httptest"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
 	"sort"
 	"strings"
 	"testing"
+
+	"github.com/antchfx/xpath"
 )
 
 const (
@@ -182,6 +188,24 @@ func TestLargeFloat(t *testing.T) {
 	}
 }
 
+func TestNumberLiteral(t *testing.T) {
+	s := `[10000000000000000001, 0.1, 1.10, -0, 1e3, 2.5E-7, 123456789.123456789]`
+	doc, err := parseString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, n := range doc.ChildNodes() {
+		if n.FirstChild.ValueType != NumberValue {
+			t.Fatalf("expected a number but %v", n.FirstChild.ValueType)
+		}
+		texts = append(texts, n.InnerText())
+	}
+	if e, g := "10000000000000000001,0.1,1.10,-0,1e3,2.5E-7,123456789.123456789", strings.Join(texts, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
 func TestNodeSelectElement(t *testing.T) {
 	top, err := parseString(testJSON)
 	if err != nil {
@@ -223,6 +247,28 @@ func TestNodeSelectElements(t *testing.T) {
 	}
 }
 
+func TestNodeSelectMalformedQuery(t *testing.T) {
+	top, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := top.SelectElement("cars/*["); n != nil {
+		t.Fatal("expected nil for a malformed query")
+	}
+	if ns := top.SelectElements("//name[="); ns != nil {
+		t.Fatal("expected nil for a malformed query")
+	}
+	if _, err := Query(top, "cars/*["); err == nil {
+		t.Fatal("expected Query to report the error")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected FindOne to panic")
+		}
+	}()
+	FindOne(top, "cars/*[")
+}
+
 func TestNodeQuery(t *testing.T) {
 	top, err := parseString(testJSON)
 	if err != nil {
@@ -329,6 +375,18 @@ func TestNodeQuerySelectorAll(t *testing.T) {
 	}
 }
 
+func TestInnerTextLen(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range append([]*Node{doc}, Find(doc, "//*")...) {
+		if e, g := len(n.InnerText()), n.InnerTextLen(); e != g {
+			t.Fatalf("expected %v but %v", e, g)
+		}
+	}
+}
+
 func TestOutputXML(t *testing.T) {
 	top, err := parseString(testJSON)
 	if err != nil {
@@ -353,21 +411,1442 @@ func TestOutputXML(t *testing.T) {
 	}
 }
 
-func TestLoadURLSuccess(t *testing.T) {
-	contentTypes := []string{
-		"application/json",
-		"application/geo+json",
+func TestOutputXMLWithOptions(t *testing.T) {
+	doc, err := ParseWithOptions(strings.NewReader(`{"b":{"y":1,"x":[]},"a":["p","q"],"c":null}`), ParserOptions{PreserveOrder: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := `<?xml version="1.0"?><b><y>1</y><x></x></b><a><element>p</element><element>q</element></a><c></c>`, doc.OutputXML(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	e := `<?xml version="1.0"?>
+<a>
+  <element>p</element>
+  <element>q</element>
+</a>
+<b>
+  <x/>
+  <y>1</y>
+</b>
+<c/>`
+	opts := XMLOptions{SortKeys: true, SelfClose: true, Indent: "  "}
+	if g := doc.OutputXMLWithOptions(opts); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	// A custom comparator, here reverse order, replaces byte-wise
+	// comparison.
+	opts = XMLOptions{SortKeys: true, KeyLess: func(a, b string) bool { return a > b }}
+	e = `<?xml version="1.0"?><c></c><b><y>1</y><x></x></b><a><element>p</element><element>q</element></a>`
+	if g := doc.OutputXMLWithOptions(opts); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	opts = XMLOptions{KeyLess: func(a, b string) bool { return a > b }}
+	if e, g := doc.OutputXML(), doc.OutputXMLWithOptions(opts); e != g {
+		t.Fatalf("expected KeyLess to apply only with SortKeys, got %v", g)
+	}
+	opts = XMLOptions{Prefix: "#", Indent: "\t"}
+	e = "<?xml version=\"1.0\"?>\n#<element>\n#\t<element>1</element>\n#</element>"
+	arr, err := parseString(`[[1]]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := arr.OutputXMLWithOptions(opts); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestOutputXMLIndent(t *testing.T) {
+	doc, err := parseString(`{"name":"John","cars":[{"name":"Ford","models":["Fiesta",["a"]]}],"empty":{}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := `<?xml version="1.0"?>
+<cars>
+	<element>
+		<models>
+			<element>Fiesta</element>
+			<element>
+				<element>a</element>
+			</element>
+		</models>
+		<name>Ford</name>
+	</element>
+</cars>
+<empty></empty>
+<name>John</name>`
+	if g := doc.OutputXMLIndent("", "\t"); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	// The layout matches xml.MarshalIndent.
+	type car struct {
+		Name string `xml:"name"`
+	}
+	b, err := xml.MarshalIndent(struct {
+		XMLName xml.Name `xml:"cars"`
+		Cars    []car    `xml:"element"`
+	}{Cars: []car{{"Ford"}, {"BMW"}}}, "> ", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err = parseString(`{"cars":[{"name":"Ford"},{"name":"BMW"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := `<?xml version="1.0"?>`+"\n"+string(b), doc.OutputXMLIndent("> ", "  "); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestOutputXMLArrayElementName(t *testing.T) {
+	doc, err := parseString(`{"cars":["Ford",["BMW","Fiat"]],"categories":[1],"boxes":[2],"data":[3]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := `<?xml version="1.0"?><boxes><item>2</item></boxes><cars><item>Ford</item><item><item>BMW</item><item>Fiat</item></item></cars><categories><item>1</item></categories><data><item>3</item></data>`
+	if g := doc.OutputXMLWithOptions(XMLOptions{ArrayElementName: "item"}); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	e = `<?xml version="1.0"?><boxes><box>2</box></boxes><cars><car>Ford</car><car><element>BMW</element><element>Fiat</element></car></cars><categories><category>1</category></categories><data><element>3</element></data>`
+	if g := doc.OutputXMLWithOptions(XMLOptions{SingularNames: true}); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	e = `<?xml version="1.0"?><boxes><box>2</box></boxes><cars><car>Ford</car><car><v>BMW</v><v>Fiat</v></car></cars><categories><category>1</category></categories><data><v>3</v></data>`
+	if g := doc.OutputXMLWithOptions(XMLOptions{SingularNames: true, ArrayElementName: "v"}); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestOutputXMLEscape(t *testing.T) {
+	v := `a < b & c > "d" 'e'`
+	doc, err := parseString(`{"note":{"text":"a < b & c > \"d\" 'e'"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var note struct {
+		Text string `xml:"text"`
+	}
+	s := doc.OutputXML()
+	if err := xml.Unmarshal([]byte(s), &note); err != nil {
+		t.Fatalf("%v: %v", s, err)
+	}
+	if note.Text != v {
+		t.Fatalf("expected %v but %v", v, note.Text)
+	}
+}
+
+func TestOutputXMLNames(t *testing.T) {
+	doc, err := ParseWithOrder(strings.NewReader(`{"first name":1,"2nd":2,"@type":3,"a.b-c_d":4,"ns:x":5,"ключ":6,"-x":7,"a&b<c>":8}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := doc.OutputXML()
+	e := `<?xml version="1.0"?><first_name>1</first_name><_2nd>2</_2nd><_type>3</_type><a.b-c_d>4</a.b-c_d>` +
+		`<ns:x>5</ns:x><ключ>6</ключ><_-x>7</_-x><a_b_c_>8</a_b_c_>`
+	if s != e {
+		t.Fatalf("expected %v but %v", e, s)
+	}
+	dec := xml.NewDecoder(strings.NewReader(s))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", s, err)
+		}
+	}
+	raw := doc.OutputXMLWithOptions(XMLOptions{RawNames: true})
+	if e := `<?xml version="1.0"?><first name>1</first name><2nd>2</2nd>`; !strings.HasPrefix(raw, e) {
+		t.Fatalf("expected %v but %v", e, raw)
+	}
+}
+
+func TestOutputXMLNamespaces(t *testing.T) {
+	doc, err := parseString(`{"ns:field":"a","ns:group":{"ns:item":"b"},"plain":"c","other:x":"d"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := XMLOptions{Namespaces: map[string]string{"ns": "urn:example"}}
+	e := `<?xml version="1.0"?>` +
+		`<ns:field xmlns:ns="urn:example">a</ns:field>` +
+		`<ns:group xmlns:ns="urn:example"><ns:item>b</ns:item></ns:group>` +
+		`<other:x>d</other:x>` +
+		`<plain>c</plain>`
+	if g := doc.OutputXMLWithOptions(opts); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestReverseChildren(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cars := doc.SelectElement("cars")
+	cars.ReverseChildren()
+	var forward []string
+	for n := cars.FirstChild; n != nil; n = n.NextSibling {
+		forward = append(forward, n.SelectElement("name").InnerText())
+	}
+	if e, g := "Fiat,BMW,Ford", strings.Join(forward, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	var backward []string
+	for n := cars.LastChild; n != nil; n = n.PrevSibling {
+		backward = append(backward, n.SelectElement("name").InnerText())
+	}
+	if e, g := "Ford,BMW,Fiat", strings.Join(backward, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if n := cars.ChildNodes()[0]; n.PrevSibling != nil || n.SelectElement("name").InnerText() != "Fiat" {
+		t.Fatal("first child should be the former last element")
+	}
+}
+
+func TestAsDocument(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	car := doc.SelectElement("cars/*[2]")
+	sub := car.AsDocument()
+	if sub.Type != DocumentNode || sub.Parent != nil || sub.Level() != 0 {
+		t.Fatal("expected a detached DocumentNode")
+	}
+	if e, g := "BMW", sub.SelectElement("name").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 3, len(sub.SelectElements("//models/*")); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := `{"models":["320","X3","X5"],"name":"BMW"}`, sub.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := `<?xml version="1.0"?><models><element>320</element><element>X3</element><element>X5</element></models><name>BMW</name>`, sub.OutputXML(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 1, sub.SelectElement("name").Level(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	sub.SelectElement("name").FirstChild.Data = "Audi"
+	if e, g := "BMW", car.SelectElement("name").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	for _, tc := range []struct {
+		n *Node
+		e string
+	}{
+		{doc, doc.OutputJSON()},
+		{doc.SelectElement("name"), `"John"`},
+		{doc.SelectElement("age/text()"), `30`},
+		{doc.SelectElement("cars/*[1]/models"), `["Fiesta","Focus","Mustang"]`},
+	} {
+		if g := tc.n.AsDocument().OutputJSON(); tc.e != g {
+			t.Fatalf("expected %v but %v", tc.e, g)
+		}
+	}
+	empty, err := parseString(`{"a":[]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := `[]`, empty.SelectElement("a").AsDocument().OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestClone(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := doc.OutputJSON()
+	c := doc.Clone()
+	if c.Type != DocumentNode || c.Parent != nil {
+		t.Fatal("expected a detached DocumentNode")
+	}
+	if e, g := src, c.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	Walk(c, func(n *Node) bool {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Parent != n || child.level != n.level+1 {
+				t.Fatalf("inconsistent child %v of %v", child, n)
+			}
+			if child.NextSibling != nil && child.NextSibling.PrevSibling != child {
+				t.Fatalf("inconsistent sibling of %v", child)
+			}
+		}
+		return true
+	})
+
+	cars := c.SelectElement("cars")
+	cars.RemoveChild(cars.FirstChild)
+	cars.FirstChild.SelectElement("name").SetData("model")
+	c.SelectElement("name").FirstChild.Data = "Jane"
+	if e, g := src, doc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	car := doc.SelectElement("cars/*[2]")
+	cc := car.Clone()
+	if cc.Type != ElementNode || cc.Parent != nil || cc.NextSibling != nil || cc.PrevSibling != nil {
+		t.Fatal("expected a detached ElementNode")
+	}
+	if e, g := car.Level(), cc.Level(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := car.OutputJSON(), cc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
 	}
+}
 
-	for _, contentType := range contentTypes {
-		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", contentType)
-			w.Write([]byte(testJSON))
-		}))
-		defer server.Close()
-		_, err := LoadURL(server.URL)
+func TestTopLevelScalars(t *testing.T) {
+	for _, tc := range []struct {
+		s, data string
+		typ     ValueType
+		v       interface{}
+	}{
+		{`42`, "42", NumberValue, float64(42)},
+		{` -1.5e3 `, "-1.5e3", NumberValue, float64(-1500)},
+		{`"hello"`, "hello", StringValue, "hello"},
+		{`""`, "", StringValue, ""},
+		{`true`, "true", BoolValue, true},
+		{`false`, "false", BoolValue, false},
+		{`null`, "", NullValue, nil},
+	} {
+		doc, err := parseString(tc.s)
 		if err != nil {
 			t.Fatal(err)
 		}
+		v := doc.Value()
+		if v == nil || v.Type != TextNode || v.Parent != doc || v.Level() != 1 || doc.FirstChild != v || doc.LastChild != v {
+			t.Fatalf("%s: expected the only child of the document to hold the value but %s", tc.s, doc.Dump())
+		}
+		if v.Data != tc.data || v.ValueType != tc.typ {
+			t.Fatalf("%s: expected %q %v but %q %v", tc.s, tc.data, tc.typ, v.Data, v.ValueType)
+		}
+		if g := doc.ToValue(); tc.v != g {
+			t.Fatalf("%s: expected %v but %v", tc.s, tc.v, g)
+		}
+		if e, g := strings.TrimSpace(tc.s), doc.OutputJSON(); e != g && tc.typ != NumberValue {
+			t.Fatalf("%s: expected %v but %v", tc.s, e, g)
+		}
+		if e, g := v, FindOne(doc, "/text()"); e != g {
+			t.Fatalf("%s: expected %v but %v", tc.s, e, g)
+		}
+		if e, g := doc, v.Root(); e != g {
+			t.Fatalf("%s: expected %v but %v", tc.s, e, g)
+		}
+		if n := doc.SelectElement("*"); n != nil {
+			t.Fatalf("%s: expected no element but %v", tc.s, n)
+		}
+	}
+
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := doc.Value(); v != nil {
+		t.Fatalf("expected nil but %v", v)
+	}
+	if v := doc.SelectElement("cars").Value(); v != nil {
+		t.Fatalf("expected nil but %v", v)
+	}
+	name := doc.SelectElement("cars/*[1]/name")
+	if e, g := "Ford", name.Value().Data; e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := name.FirstChild, name.FirstChild.Value(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := doc, name.Root(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if c := name.Clone(); c.Root() != c {
+		t.Fatal("expected a detached node to be its own root")
+	}
+}
+
+func TestAncestors(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := doc.SelectElement("cars/*[2]/models/*[3]/text()")
+	if e, g := "X5", text.Data; e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	var keys []string
+	for _, n := range text.Ancestors() {
+		keys = append(keys, n.String())
+	}
+	e := `Element "",Element "models" (array),Element "" (object),Element "cars" (array),Document (object)`
+	if g := strings.Join(keys, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if a := doc.Ancestors(); len(a) != 0 {
+		t.Fatalf("expected no ancestors but %v", a)
+	}
+
+	models := text.SelectAncestor("models")
+	if models == nil || models.Parent.SelectElement("name").InnerText() != "BMW" {
+		t.Fatalf("expected the models of BMW but %v", models)
+	}
+	if e, g := doc.SelectElement("cars"), text.SelectAncestor("cars"); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	// Array elements have an empty key.
+	if e, g := text.Parent, text.SelectAncestor(""); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if n := text.SelectAncestor("name"); n != nil {
+		t.Fatalf("expected nil but %v", n)
+	}
+	if n := doc.SelectElement("cars").SelectAncestor("cars"); n != nil {
+		t.Fatalf("expected nil but %v", n)
+	}
+
+	// Find the car each name belongs to.
+	var cars []string
+	for _, n := range doc.SelectElements("//models/*") {
+		if n.InnerText() == "Focus" || n.InnerText() == "Panda" {
+			car := n.SelectAncestor("models").Parent
+			cars = append(cars, car.SelectElement("name").InnerText())
+		}
+	}
+	if e, g := "Ford,Fiat", strings.Join(cars, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestCommonAncestor(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bmw := doc.SelectElement("cars/*[2]")
+	name := bmw.SelectElement("name")
+	x5 := bmw.SelectElement("models/*[3]")
+	if n := CommonAncestor(name, x5); n != bmw {
+		t.Fatalf("expected the BMW element but %v", n)
+	}
+	if n := CommonAncestor(bmw, x5.FirstChild); n != bmw {
+		t.Fatalf("expected the BMW element but %v", n)
+	}
+	ford := doc.SelectElement("cars/*[1]/name")
+	if n := CommonAncestor(ford, x5); n != doc.SelectElement("cars") {
+		t.Fatalf("expected the cars element but %v", n)
+	}
+	if n := CommonAncestor(doc.SelectElement("age"), name); n != doc {
+		t.Fatalf("expected the document but %v", n)
+	}
+	other, _ := parseString(testJSON)
+	if n := CommonAncestor(name, other.SelectElement("name")); n != nil {
+		t.Fatalf("expected nil but %v", n)
+	}
+}
+
+func TestParseEmptyString(t *testing.T) {
+	doc, err := parseString(`{"x":""}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := doc.SelectElement("x")
+	if x == nil {
+		t.Fatal("x is nil")
+	}
+	if x.FirstChild == nil || x.FirstChild != x.LastChild {
+		t.Fatal("x should have a single text child")
+	}
+	if x.FirstChild.Type != TextNode || x.FirstChild.Data != "" {
+		t.Fatalf("expected an empty text node but %v %q", x.FirstChild.Type, x.FirstChild.Data)
+	}
+	if e, g := `<?xml version="1.0"?><x></x>`, doc.OutputXML(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	doc, err := parseString(`{"prices":[8.5, 12, 3.5, 20], "name":"John"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	min, max, sum, avg, count, err := doc.Aggregate("prices/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if min != 3.5 || max != 20 || sum != 44 || avg != 11 || count != 4 {
+		t.Fatalf("unexpected statistics: min=%v max=%v sum=%v avg=%v count=%v", min, max, sum, avg, count)
+	}
+	if _, _, _, _, count, err = doc.Aggregate("missing/*"); err != nil || count != 0 {
+		t.Fatalf("expected no matches but %v, %v", count, err)
+	}
+	if _, _, _, _, _, err = doc.Aggregate("name"); err == nil {
+		t.Fatal("expected an error for a non-numeric match")
+	}
+	if _, _, _, _, _, err = doc.Aggregate("[["); err == nil {
+		t.Fatal("expected an error for an invalid query")
+	}
+}
+
+func TestInsertSibling(t *testing.T) {
+	doc, err := parseString(`{"models":["Focus","Fiesta"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	models := doc.SelectElement("models")
+	newElement := func(s string) *Node {
+		n := &Node{Type: ElementNode}
+		n.FirstChild = &Node{Type: TextNode, Data: s, Parent: n}
+		n.LastChild = n.FirstChild
+		return n
+	}
+	models.FirstChild.InsertBefore(newElement("Ka"))
+	models.LastChild.InsertAfter(newElement("Puma"))
+	models.FirstChild.NextSibling.InsertAfter(newElement("Kuga"))
+	var forward []string
+	for n := models.FirstChild; n != nil; n = n.NextSibling {
+		if n.Parent != models {
+			t.Fatalf("%v has the wrong parent", n.InnerText())
+		}
+		forward = append(forward, n.InnerText())
+	}
+	if e, g := "Ka,Focus,Kuga,Fiesta,Puma", strings.Join(forward, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	var backward []string
+	for n := models.LastChild; n != nil; n = n.PrevSibling {
+		backward = append(backward, n.InnerText())
+	}
+	if e, g := "Puma,Fiesta,Kuga,Focus,Ka", strings.Join(backward, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "Ka", models.SelectElement("*[1]").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestNodeQuerySelectorText(t *testing.T) {
+	top, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "30", top.QuerySelectorText(xpath.MustCompile("age")); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if g := top.QuerySelectorText(xpath.MustCompile("missing")); g != "" {
+		t.Fatalf("expected empty string but %v", g)
+	}
+	names := top.QuerySelectorAllText(xpath.MustCompile("/cars/*/name"))
+	if e, g := "Ford,BMW,Fiat", strings.Join(names, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func BenchmarkQuerySelectorAllText(b *testing.B) {
+	top, _ := parseString(testJSON)
+	expr := xpath.MustCompile("//models/*")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		top.QuerySelectorAllText(expr)
+	}
+}
+
+func BenchmarkQuerySelectorAllInnerText(b *testing.B) {
+	top, _ := parseString(testJSON)
+	expr := xpath.MustCompile("//models/*")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var a []string
+		for _, n := range top.QuerySelectorAll(expr) {
+			a = append(a, n.InnerText())
+		}
+	}
+}
+
+func TestDepthHistogram(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]int{0: 1, 1: 4, 2: 6, 3: 6, 4: 11, 5: 8}
+	m := doc.DepthHistogram()
+	if len(m) != len(expected) {
+		t.Fatalf("expected %v but %v", expected, m)
+	}
+	for depth, count := range expected {
+		if m[depth] != count {
+			t.Fatalf("expected %v nodes at depth %v but %v", count, depth, m[depth])
+		}
+	}
+	m = doc.SelectElement("cars").DepthHistogram()
+	if m[1] != 1 || m[2] != 3 || m[5] != 8 {
+		t.Fatalf("unexpected histogram %v", m)
+	}
+}
+
+func TestOutputDOT(t *testing.T) {
+	doc, err := parseString(`{"name":"John","cars":[{"name":"Ford"}],"note":"a very long piece of text"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := doc.OutputDOT()
+	if !strings.HasPrefix(dot, "digraph jsonquery {\n") || !strings.HasSuffix(dot, "}\n") {
+		t.Fatalf("unexpected graph %v", dot)
+	}
+	for _, s := range []string{
+		`n0 [label="document", shape=doublecircle];`,
+		`[label="cars", shape=ellipse];`,
+		`[label="element", shape=ellipse];`,
+		`[label="Ford", shape=box];`,
+		`[label="a very long piece of...", shape=box];`,
+		`n0 -> n1;`,
+	} {
+		if !strings.Contains(dot, s) {
+			t.Fatalf("expected %v in %v", s, dot)
+		}
+	}
+}
+
+func TestReplaceText(t *testing.T) {
+	doc, err := parseString(`{"name":"John","age":30,"car":{"name":"Ford"},"empty":{}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.SelectElement("name").ReplaceText("Jane"); err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "Jane", doc.SelectElement("name").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if err := doc.SelectElement("age").FirstChild.ReplaceText("31"); err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "31", doc.SelectElement("age").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if err := doc.SelectElement("empty").ReplaceText("set"); err != nil {
+		t.Fatal(err)
+	}
+	if n := doc.SelectElement("empty/text()"); n == nil || n.Data != "set" {
+		t.Fatal("expected a new text child")
+	}
+	if err := doc.SelectElement("car").ReplaceText("BMW"); err == nil {
+		t.Fatal("expected an error for a node with element children")
+	}
+	if e, g := `{"age":31,"car":{"name":"Ford"},"empty":"set","name":"Jane"}`, doc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	// A replaced null becomes a string; numbers and booleans keep
+	// their type and are quoted only if the text is not a valid literal.
+	doc, err = parseString(`{"spouse":null,"port":443,"age":30,"motorist":true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		key, text string
+		e         ValueType
+	}{
+		{"spouse", "Jane", StringValue},
+		{"port", "8443", NumberValue},
+		{"age", "thirty", NumberValue},
+		{"motorist", "false", BoolValue},
+	} {
+		if err := doc.SelectElement(tc.key).ReplaceText(tc.text); err != nil {
+			t.Fatal(err)
+		}
+		if g := doc.SelectElement(tc.key).FirstChild.ValueType; tc.e != g {
+			t.Fatalf("%s: expected %v but %v", tc.key, tc.e, g)
+		}
+	}
+	if e, g := `{"age":"thirty","motorist":false,"port":8443,"spouse":"Jane"}`, doc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestNodeQuerySelectorExists(t *testing.T) {
+	top, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !top.QuerySelectorExists(xpath.MustCompile("//models/*[.='X5']")) {
+		t.Fatal("expected a match")
+	}
+	if top.QuerySelectorExists(xpath.MustCompile("//models/*[.='A4']")) {
+		t.Fatal("expected no match")
+	}
+}
+
+func BenchmarkQuerySelectorExists(b *testing.B) {
+	top, _ := parseString(testJSON)
+	expr := xpath.MustCompile("//models/*")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		top.QuerySelectorExists(expr)
+	}
+}
+
+func BenchmarkQuerySelectorAllExists(b *testing.B) {
+	top, _ := parseString(testJSON)
+	expr := xpath.MustCompile("//models/*")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = len(top.QuerySelectorAll(expr)) > 0
+	}
+}
+
+func TestNodeQuerySelectorCount(t *testing.T) {
+	top, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		expr string
+		e    int
+	}{
+		{"//models/*", 8},
+		{"cars/*[name='BMW']/models/*", 3},
+		{"//models/*[.='A4']", 0},
+	} {
+		expr := xpath.MustCompile(tc.expr)
+		if g := top.QuerySelectorCount(expr); tc.e != g {
+			t.Fatalf("%v: expected %v but %v", tc.expr, tc.e, g)
+		}
+	}
+}
+
+func BenchmarkQuerySelectorCount(b *testing.B) {
+	top, _ := parseString(testJSON)
+	expr := xpath.MustCompile("//models/*")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		top.QuerySelectorCount(expr)
+	}
+}
+
+func BenchmarkQuerySelectorAllCount(b *testing.B) {
+	top, _ := parseString(testJSON)
+	expr := xpath.MustCompile("//models/*")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = len(top.QuerySelectorAll(expr))
+	}
+}
+
+func TestSortChildren(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cars := doc.SelectElement("cars")
+	cars.SortChildren(func(a, b *Node) bool {
+		return a.SelectElement("name").InnerText() < b.SelectElement("name").InnerText()
+	})
+	var forward []string
+	for n := cars.FirstChild; n != nil; n = n.NextSibling {
+		forward = append(forward, n.SelectElement("name").InnerText())
+	}
+	if e, g := "BMW,Fiat,Ford", strings.Join(forward, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	var backward []string
+	for n := cars.LastChild; n != nil; n = n.PrevSibling {
+		backward = append(backward, n.SelectElement("name").InnerText())
+	}
+	if e, g := "Ford,Fiat,BMW", strings.Join(backward, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "Fiat", doc.SelectElement("cars/*[2]/name").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	// The sort is stable.
+	doc, err = parseString(`[{"k":2,"v":"a"},{"k":1,"v":"b"},{"k":2,"v":"c"},{"k":1,"v":"d"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.SortChildren(func(a, b *Node) bool {
+		return a.SelectElement("k").InnerText() < b.SelectElement("k").InnerText()
+	})
+	var values []string
+	for _, n := range doc.ChildNodes() {
+		values = append(values, n.SelectElement("v").InnerText())
+	}
+	if e, g := "b,d,a,c", strings.Join(values, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestEachChild(t *testing.T) {
+	doc, err := parseString(`[10,20,30,40]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var visited []string
+	doc.EachChild(func(i int, child *Node) bool {
+		visited = append(visited, fmt.Sprintf("%d:%s", i, child.InnerText()))
+		return true
+	})
+	if e, g := "0:10,1:20,2:30,3:40", strings.Join(visited, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	visited = visited[:0]
+	doc.EachChild(func(i int, child *Node) bool {
+		visited = append(visited, child.InnerText())
+		return i < 1
+	})
+	if e, g := "10,20", strings.Join(visited, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestSelectElementByKey(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.keys != nil {
+		t.Fatal("objects should not be indexed by default")
+	}
+	if n := doc.SelectElementByKey("name"); n == nil || n.InnerText() != "John" {
+		t.Fatal("expected to find name by key")
+	}
+	if n := doc.SelectElementByKey("city"); n != nil {
+		t.Fatal("expected no match")
+	}
+
+	doc, err = ParseWithOptions(strings.NewReader(testJSON), ParserOptions{IndexKeys: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	city := &Node{Type: ElementNode, Data: "city"}
+	doc.SelectElementByKey("age").InsertAfter(city)
+	if doc.keys != nil {
+		t.Fatal("inserting a child should drop the index")
+	}
+	if n := doc.SelectElementByKey("city"); n != city {
+		t.Fatal("expected to find the inserted node")
+	}
+}
+
+func TestForEachMatch(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	err = doc.ForEachMatch("//name", func(n *Node) error {
+		names = append(names, n.InnerText())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "Ford,BMW,Fiat,John", strings.Join(names, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	stop := errors.New("stop")
+	names = names[:0]
+	err = doc.ForEachMatch("//name", func(n *Node) error {
+		names = append(names, n.InnerText())
+		if n.InnerText() == "BMW" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("expected %v but %v", stop, err)
+	}
+	if e, g := "Ford,BMW", strings.Join(names, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if err := doc.ForEachMatch("[[", func(*Node) error { return nil }); err == nil {
+		t.Fatal("expected an error for an invalid query")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// Attach the cars node below one of its own descendants.
+	cars := doc.SelectElement("cars")
+	models := cars.SelectElement("*[2]/models")
+	models.LastChild.InsertAfter(cars)
+	err = cars.Validate()
+	if err == nil {
+		t.Fatal("expected an error for a cycle")
+	}
+	if !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	doc, _ = parseString(testJSON)
+	doc.SelectElement("age").NextSibling = doc.SelectElement("name")
+	if err := doc.Validate(); err == nil {
+		t.Fatal("expected an error for broken sibling links")
+	}
+}
+
+func TestReplaceAllText(t *testing.T) {
+	doc, err := parseString(`{
+		"host": "${ENV}.example.com",
+		"env": "${ENV}",
+		"stages": ["${ENV}", "prod", "${ENV}"],
+		"${ENV}": "key"
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 3, doc.ReplaceAllText("${ENV}", "staging"); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "staging", doc.SelectElement("env").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "staging,prod,staging", strings.Join(doc.SelectElement("stages").QuerySelectorAllText(xpath.MustCompile("*")), ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "${ENV}.example.com", doc.SelectElement("host").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if doc.SelectElementByKey("${ENV}") == nil {
+		t.Fatal("keys should not be replaced")
+	}
+	nums, err := parseString(`{"a":30,"b":null,"c":"","d":443,"e":true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nums.ReplaceAllText("30", "thirty")
+	nums.ReplaceAllText("", "none")
+	nums.ReplaceAllTextRegexp(regexp.MustCompile(`^443$`), "8443")
+	nums.ReplaceAllTextRegexp(regexp.MustCompile(`^true$`), "True")
+	for key, e := range map[string]ValueType{"a": NumberValue, "b": StringValue, "c": StringValue, "d": NumberValue, "e": BoolValue} {
+		if g := nums.SelectElement(key + "/text()").ValueType; e != g {
+			t.Fatalf("%s: expected %v but %v", key, e, g)
+		}
+	}
+	if e, g := `{"a":"thirty","b":"none","c":"none","d":8443,"e":"True"}`, nums.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	re := regexp.MustCompile(`\$\{(\w+)\}`)
+	if e, g := 1, doc.ReplaceAllTextRegexp(re, "<$1>"); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "<ENV>.example.com", doc.SelectElement("host").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+// countdownContext is a context that reports itself canceled after
+// Err has been called a given number of times.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestQueryAllContextLimit(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := doc.QueryAllContextLimit(context.Background(), "//models/*", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var models []string
+	for _, n := range nodes {
+		models = append(models, n.InnerText())
+	}
+	if e, g := "Fiesta,Focus,Mustang,320", strings.Join(models, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	nodes, err = doc.QueryAllContextLimit(context.Background(), "//models/*", 0)
+	if err != nil || len(nodes) != 8 {
+		t.Fatalf("expected 8 nodes but %v, %v", len(nodes), err)
+	}
+
+	ctx := &countdownContext{Context: context.Background(), n: 2}
+	nodes, err = doc.QueryAllContextLimit(ctx, "//models/*", 10)
+	if err != context.Canceled {
+		t.Fatalf("expected %v but %v", context.Canceled, err)
+	}
+	if len(nodes) != 2 || nodes[1].InnerText() != "Focus" {
+		t.Fatalf("expected the two matches found before cancellation but %v", len(nodes))
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if nodes, err := doc.QueryAllContextLimit(canceled, "//models/*", 0); err != context.Canceled || len(nodes) != 0 {
+		t.Fatalf("expected no matches and %v but %v, %v", context.Canceled, len(nodes), err)
+	}
+	if _, err := doc.QueryAllContextLimit(context.Background(), "[[", 0); err == nil {
+		t.Fatal("expected an error for an invalid query")
+	}
+}
+
+func TestIndexInParent(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"Ford", "BMW", "Fiat"} {
+		n := doc.SelectElement("cars/*[name='" + name + "']")
+		if g := n.IndexInParent(); g != i {
+			t.Fatalf("%v: expected %v but %v", name, i, g)
+		}
+	}
+	if e, g := 2, doc.SelectElement("//models/*[.='X5']").IndexInParent(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := -1, doc.IndexInParent(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestTextOfChildren(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	models := doc.SelectElement("cars/*[name='Ford']/models")
+	for _, tc := range []struct {
+		start, end int
+		e          string
+	}{
+		{1, 3, "Focus,Mustang"},
+		{0, 1, "Fiesta"},
+		{-5, 2, "Fiesta,Focus"},
+		{2, 10, "Mustang"},
+		{3, 10, ""},
+		{2, 1, ""},
+	} {
+		if g := strings.Join(models.TextOfChildren(tc.start, tc.end), ","); tc.e != g {
+			t.Fatalf("[%d, %d): expected %v but %v", tc.start, tc.end, tc.e, g)
+		}
+	}
+}
+
+func TestSelectChildrenGlob(t *testing.T) {
+	doc, err := parseString(`{"addr_home":1,"addr_work":2,"item1":3,"item2":4,"itemX":5,"name":6}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		pattern, e string
+	}{
+		{"addr_*", "addr_home,addr_work"},
+		{"item?", "item1,item2,itemX"},
+		{"item[0-9]", "item1,item2"},
+		{"item[^0-9]", "itemX"},
+		{"*", "addr_home,addr_work,item1,item2,itemX,name"},
+		{"nothing*", ""},
+		{"[", ""},
+	} {
+		var keys []string
+		for _, n := range doc.SelectChildrenGlob(tc.pattern) {
+			keys = append(keys, n.Data)
+		}
+		if g := strings.Join(keys, ","); tc.e != g {
+			t.Fatalf("%v: expected %v but %v", tc.pattern, tc.e, g)
+		}
+	}
+	arr, err := parseString(`[1,2]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := arr.SelectChildrenGlob("*"); len(n) != 0 {
+		t.Fatalf("expected no nodes but %v", len(n))
+	}
+}
+
+func TestTrimChildren(t *testing.T) {
+	for _, tc := range []struct {
+		s, e string
+	}{
+		{`["","a","","b",null]`, `["a","","b"]`},
+		{`[[],"a",{}]`, `["a"]`},
+		{`["a","b"]`, `["a","b"]`},
+		{`["",null]`, `[]`},
+		{`{"a":"","b":1,"c":null}`, `{"b":1}`},
+	} {
+		doc, err := parseString(tc.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc.TrimChildren()
+		if err := doc.Validate(); err != nil {
+			t.Fatalf("%v: %v", tc.s, err)
+		}
+		if g := doc.OutputJSONDepth(0); tc.e != g {
+			t.Fatalf("expected %v but %v", tc.e, g)
+		}
+	}
+}
+
+func TestSplice(t *testing.T) {
+	newNodes := func(values ...string) []*Node {
+		var nodes []*Node
+		for _, v := range values {
+			n := &Node{Type: ElementNode}
+			appendChild(n, &Node{Type: TextNode, Data: v, level: 1})
+			nodes = append(nodes, n)
+		}
+		return nodes
+	}
+	for _, tc := range []struct {
+		start, deleteCount int
+		insert             []string
+		e, removed         string
+	}{
+		{1, 0, []string{"x", "y"}, `["a","x","y","b","c","d"]`, ""},
+		{1, 2, nil, `["a","d"]`, "b,c"},
+		{1, 2, []string{"x", "y", "z"}, `["a","x","y","z","d"]`, "b,c"},
+		{-1, 1, []string{"x"}, `["a","b","c","x"]`, "d"},
+		{-10, 1, nil, `["b","c","d"]`, "a"},
+		{10, 5, []string{"x"}, `["a","b","c","d","x"]`, ""},
+		{2, 10, nil, `["a","b"]`, "c,d"},
+		{0, -1, []string{"x"}, `["x","a","b","c","d"]`, ""},
+	} {
+		doc, err := parseString(`["a","b","c","d"]`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		removed := doc.Splice(tc.start, tc.deleteCount, newNodes(tc.insert...)...)
+		if err := doc.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if g := doc.OutputJSONDepth(0); tc.e != g {
+			t.Fatalf("expected %v but %v", tc.e, g)
+		}
+		var texts []string
+		for _, n := range removed {
+			if n.Parent != nil || n.PrevSibling != nil || n.NextSibling != nil {
+				t.Fatal("expected removed nodes to be detached")
+			}
+			texts = append(texts, n.InnerText())
+		}
+		if g := strings.Join(texts, ","); tc.removed != g {
+			t.Fatalf("expected %v but %v", tc.removed, g)
+		}
+		for n := doc.FirstChild; n != nil; n = n.NextSibling {
+			if n.level != 1 || n.FirstChild.level != 2 {
+				t.Fatalf("unexpected levels %v, %v", n.level, n.FirstChild.level)
+			}
+		}
+	}
+}
+
+func TestLevel(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var check func(n *Node, level int)
+	check = func(n *Node, level int) {
+		if g := n.Level(); g != level {
+			t.Fatalf("%v: expected %v but %v", n.Data, level, g)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			check(child, level+1)
+		}
+	}
+	check(doc, 0)
+	for expr, e := range map[string]int{"name": 1, "name/text()": 2, "cars/*[1]": 2, "cars/*[1]/models/*[2]/text()": 5} {
+		if g := doc.SelectElement(expr).Level(); e != g {
+			t.Fatalf("%v: expected %v but %v", expr, e, g)
+		}
+	}
+	n := &Node{Type: ElementNode, Data: "city"}
+	appendChild(n, &Node{Type: TextNode, Data: "Nara", level: 1})
+	doc.SelectElement("cars/*[1]/name").InsertAfter(n)
+	check(doc, 0)
+}
+
+func TestIsArrayIsObject(t *testing.T) {
+	doc, err := parseString(`{"a":[1],"o":{"k":1},"ea":[],"eo":{},"s":"x","n":null}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		key           string
+		array, object bool
+	}{
+		{"a", true, false},
+		{"o", false, true},
+		{"ea", true, false},
+		{"eo", false, true},
+		{"s", false, false},
+		{"n", false, false},
+	} {
+		n := doc.SelectElement(tc.key)
+		if n.IsArray() != tc.array || n.IsObject() != tc.object {
+			t.Fatalf("%v: expected %v, %v but %v, %v", tc.key, tc.array, tc.object, n.IsArray(), n.IsObject())
+		}
+	}
+	if !doc.IsObject() || doc.IsArray() {
+		t.Fatal("expected the document to hold an object")
+	}
+	typed, err := ParseTypedJSON(strings.NewReader(`{"type":"array","value":[{"type":"object","value":{}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !typed.IsArray() || !typed.SelectElement("*[1]").IsObject() {
+		t.Fatal("expected ParseTypedJSON to record the container types")
+	}
+	if !doc.SelectElement("ea").AsDocument().IsArray() {
+		t.Fatal("expected AsDocument to keep the container type")
+	}
+
+	// Without Container, the children decide.
+	n := &Node{Type: ElementNode}
+	if n.IsArray() || n.IsObject() {
+		t.Fatal("expected an empty node to be neither")
+	}
+	appendChild(n, &Node{Type: ElementNode})
+	if !n.IsArray() || n.IsObject() {
+		t.Fatal("expected an array")
+	}
+	n.FirstChild.Data = "key"
+	if n.IsArray() || !n.IsObject() {
+		t.Fatal("expected an object")
+	}
+}
+
+func TestDeepTree(t *testing.T) {
+	const depth = 100000
+	doc := &Node{Type: DocumentNode}
+	top := doc
+	for i := 1; i <= depth; i++ {
+		n := &Node{Type: ElementNode, level: i}
+		appendChild(top, n)
+		top = n
+	}
+	appendChild(top, &Node{Type: TextNode, Data: "x", level: depth + 1})
+	s := doc.OutputXML()
+	e := `<?xml version="1.0"?>` + strings.Repeat("<element>", depth) + "x" + strings.Repeat("</element>", depth)
+	if s != e {
+		t.Fatal("unexpected output for a deeply nested tree")
+	}
+	if e, g := "x", doc.InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 1, doc.InnerTextLen(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	Walk(doc, func(n *Node) bool {
+		count++
+		return true
+	})
+	// The document, 4 members, 3 texts, 3 cars with 2 members each and a
+	// text for their name, and 8 models with a text each.
+	if e := 1 + 4 + 3 + 3*(1+2+1) + 8*2; count != e {
+		t.Fatalf("expected %v but %v", e, count)
+	}
+
+	var order []string
+	Walk(doc.SelectElement("cars/*[1]"), func(n *Node) bool {
+		if n.Type == TextNode {
+			order = append(order, n.Data)
+		} else {
+			order = append(order, "<"+n.Data+">")
+		}
+		return true
+	})
+	if e, g := "<>,<models>,<>,Fiesta,<>,Focus,<>,Mustang,<name>,Ford", strings.Join(order, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	// Prune the cars.
+	var keys []string
+	Walk(doc, func(n *Node) bool {
+		if n.Type == ElementNode {
+			keys = append(keys, n.Data)
+		}
+		return n.Data != "cars"
+	})
+	if e, g := "age,cars,motorist,name", strings.Join(keys, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	// Stop at the first model.
+	var first *Node
+	count = 0
+	WalkWithBreak(doc, func(n *Node) (bool, bool) {
+		count++
+		if n.Parent != nil && n.Parent.Data == "models" {
+			first = n
+			return false, true
+		}
+		return true, false
+	})
+	if first == nil || first.InnerText() != "Fiesta" {
+		t.Fatal("expected the walk to stop at Fiesta")
+	}
+	// The document, age and its text, cars, the first car and its models.
+	if e := 1 + 2 + 1 + 1 + 1 + 1; count != e {
+		t.Fatalf("expected %v but %v", e, count)
+	}
+}
+
+func TestAddRemoveChild(t *testing.T) {
+	doc, err := ParseWithOptions(strings.NewReader(`{"a":1,"b":2,"c":3}`), ParserOptions{IndexKeys: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := doc.SelectElementByKey("a"), doc.SelectElementByKey("b"), doc.SelectElementByKey("c")
+
+	// Remove the middle, first and last child in turn.
+	doc.RemoveChild(b)
+	if e, g := `{"a":1,"c":3}`, doc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if b.Parent != nil || b.PrevSibling != nil || b.NextSibling != nil {
+		t.Fatal("expected the removed node to be detached")
+	}
+	if doc.SelectElementByKey("b") != nil {
+		t.Fatal("expected the key index to be updated")
+	}
+	doc.RemoveChild(b)
+
+	// Reorder by moving a to the end, then add b back.
+	doc.AddChild(a)
+	doc.AddChild(b)
+	if e, g := `{"c":3,"a":1,"b":2}`, doc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if err := doc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Build a new member and move a node between parents.
+	d := &Node{Type: ElementNode}
+	d.SetData("d")
+	d.AddChild(&Node{Type: ElementNode})
+	d.FirstChild.AddChild(&Node{Type: TextNode, Data: "x"})
+	doc.AddChild(d)
+	d.AddChild(c.FirstChild)
+	if e, g := `{"c":null,"a":1,"b":2,"d":["x",3]}`, doc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := `<?xml version="1.0"?><c></c><a>1</a><b>2</b><d><element>x</element>3</d>`, doc.OutputXML(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 2, d.LastChild.Level(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	b.SetData("renamed")
+	if n := doc.SelectElementByKey("renamed"); n != b {
+		t.Fatal("expected to find the renamed member")
+	}
+	for _, n := range []*Node{doc.FirstChild, doc.LastChild} {
+		doc.RemoveChild(n)
+	}
+	doc.RemoveChild(doc.FirstChild)
+	doc.RemoveChild(doc.FirstChild)
+	if doc.FirstChild != nil || doc.LastChild != nil {
+		t.Fatal("expected no children")
+	}
+}
+
+func TestSetDataNull(t *testing.T) {
+	doc, err := parseString(`{"spouse":null,"age":30}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.SelectElement("spouse/text()").SetData("Jane")
+	doc.SelectElement("age/text()").SetData("31")
+	if e, g := `{"age":31,"spouse":"Jane"}`, doc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestAddChildCycle(t *testing.T) {
+	doc, err := parseString(`{"o":{"p":{"q":1}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := doc.OutputJSON()
+	o, q := doc.SelectElement("o"), doc.SelectElement("//q")
+	for _, tc := range []struct{ n, child *Node }{
+		{o, doc},
+		{o, o},
+		{q, o},
+		{q.FirstChild, q},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected AddChild of %v to %v to panic", tc.child, tc.n)
+				}
+			}()
+			tc.n.AddChild(tc.child)
+		}()
+	}
+	if g := doc.OutputJSON(); want != g {
+		t.Fatalf("expected %v but %v", want, g)
+	}
+	if err := doc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// Moving a node under a sibling is fine.
+	doc.SelectElement("o/p").AddChild(doc.SelectElement("o").Clone())
+	if e, g := `{"o":{"p":{"q":1,"o":{"p":{"q":1}}}}}`, doc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestNodeStringDump(t *testing.T) {
+	doc, err := parseString(`{"name":"John","cars":[{"models":["X3"]}],"age":30,"spouse":null}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		n *Node
+		e string
+	}{
+		{doc, "Document (object)"},
+		{doc.SelectElement("cars"), `Element "cars" (array)`},
+		{doc.SelectElement("cars/*[1]"), `Element "" (object)`},
+		{doc.SelectElement("name"), `Element "name"`},
+		{doc.SelectElement("name/text()"), `Text "John" (string)`},
+		{doc.SelectElement("age/text()"), `Text "30" (number)`},
+		{doc.SelectElement("spouse/text()"), `Text "" (null)`},
+	} {
+		if g := tc.n.String(); tc.e != g {
+			t.Fatalf("expected %v but %v", tc.e, g)
+		}
+	}
+	e := `Element "cars" (array) [level 1]
+  Element "" (object) [level 2]
+    Element "models" (array) [level 3]
+      Element "" [level 4]
+        Text "X3" (string) [level 5]
+`
+	if g := doc.SelectElement("cars").Dump(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
 	}
 }
@@ -0,0 +1,774 @@
+package jsonquery
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ParserOptions controls how ParseWithOptions builds the node tree.
+type ParserOptions struct {
+	// StrictUTF8 makes parsing fail if the document contains invalid
+	// UTF-8 or a lone UTF-16 surrogate escape such as \uDEAD. When it
+	// is false, such sequences are replaced with U+FFFD.
+	StrictUTF8 bool
+
+	// NumberFormatter, if set, is called with every JSON number and its
+	// result is used as the text of the number node, for example to
+	// round values for display. It only changes the text of the node,
+	// whose ValueType remains NumberValue. By default the text is the
+	// number exactly as written in the document, so no precision is
+	// lost to a float64 conversion.
+	NumberFormatter func(json.Number) string
+
+	// ScalarDecoders maps a kind of scalar to a function that
+	// transforms its text, as registered globally with
+	// RegisterScalarDecoder. A decoder set here applies to this parse
+	// only and takes precedence over a registered one for the same
+	// kind. Prefer it to RegisterScalarDecoder when parses with
+	// different needs run concurrently.
+	ScalarDecoders map[ValueType]func(raw string) (string, error)
+
+	// MaxNodes, if positive, limits the number of nodes, not counting
+	// the document node, the tree may contain. Parsing fails as soon as
+	// the limit is exceeded.
+	MaxNodes int
+
+	// MaxDepth, if positive, limits how deeply objects and arrays may
+	// be nested. A top-level object or array is at depth 1, so
+	// {"a":[1]} has depth 2. Parsing fails as soon as the limit is
+	// exceeded. Use it when parsing untrusted documents.
+	MaxDepth int
+
+	// InternStrings makes equal keys and string values share the same
+	// backing storage, which reduces the memory held by documents with
+	// many repeated values at the cost of a map lookup per string.
+	InternStrings bool
+
+	// Progress, if set, is called with the total number of bytes read
+	// from the input so far. It is called at most once every 64KiB and
+	// once more when the input is exhausted. It reports reading the
+	// input only; building the tree happens after the last call.
+	Progress func(bytesRead int64)
+
+	// PreserveOrder keeps the members of objects in the order they
+	// appear in the document. By default they are sorted by key.
+	PreserveOrder bool
+
+	// KeyLess, if set, is used instead of byte-wise comparison to sort
+	// the members of objects when PreserveOrder is false, for example
+	// to order keys case-insensitively or by locale. Members whose keys
+	// compare equal keep their document order.
+	KeyLess func(a, b string) bool
+
+	// IndexKeys makes every object node keep a map from key to member
+	// so that SelectElementByKey finds members in constant time. The
+	// index costs a map per object, so it is off by default; lookups
+	// then scan the children instead. Changing the children of a node
+	// through this package drops its index.
+	IndexKeys bool
+
+	// RepeatedKeysAsArray collects the values of a key that appears
+	// more than once in the same object into a single member whose
+	// children are the values in document order, as if they had been
+	// written as an array. {"tag":"a","tag":"b"} then parses like
+	// {"tag":["a","b"]}. By default every occurrence of a repeated key
+	// is kept as a separate member, in document order, as XML does with
+	// repeated elements, so that {"a":1,"a":2} has two members named a
+	// and //a selects both.
+	RepeatedKeysAsArray bool
+
+	// RejectRepeatedKeys makes parsing fail if a key appears more than
+	// once in the same object. It takes precedence over
+	// RepeatedKeysAsArray.
+	RejectRepeatedKeys bool
+}
+
+// progressInterval is the number of bytes read between calls to
+// ParserOptions.Progress.
+const progressInterval = 64 << 10
+
+// progressReader reports the number of bytes read from r to fn.
+type progressReader struct {
+	r        io.Reader
+	fn       func(int64)
+	n        int64
+	reported int64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.n += int64(n)
+	if r.n-r.reported >= progressInterval || (err == io.EOF && r.n != r.reported) {
+		r.reported = r.n
+		r.fn(r.n)
+	}
+	return n, err
+}
+
+var (
+	scalarDecoders      = make(map[ValueType]func(raw string) (string, error))
+	scalarDecodersMutex sync.RWMutex
+)
+
+// RegisterScalarDecoder registers fn to transform the text of every
+// scalar of the given kind while parsing, for example to decode base64
+// strings. fn receives the text the node would otherwise get, so for
+// numbers it runs after ParserOptions.NumberFormatter, and booleans
+// are passed as "true" or "false" and null as "". Its result becomes
+// the text of the node, whose ValueType is unchanged as long as the
+// result is a valid JSON literal of that kind: a number, true or false,
+// or "" for null. Any other result makes the node a StringValue, so
+// the tree always serializes to valid JSON. If fn returns an error,
+// parsing stops and returns it. Registering a decoder replaces the
+// previous one for kind; a nil fn removes it. Decoders apply to every parse
+// started after they are registered; use ParserOptions.ScalarDecoders
+// to set a decoder for a single parse.
+func RegisterScalarDecoder(kind ValueType, fn func(raw string) (string, error)) {
+	scalarDecodersMutex.Lock()
+	if fn == nil {
+		delete(scalarDecoders, kind)
+	} else {
+		scalarDecoders[kind] = fn
+	}
+	scalarDecodersMutex.Unlock()
+}
+
+type parser struct {
+	opts     ParserOptions
+	dec      *json.Decoder
+	nodes    int
+	depth    int
+	strings  map[string]string
+	decoders map[ValueType]func(string) (string, error)
+}
+
+func newParser(r io.Reader, opts ParserOptions) *parser {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	p := &parser{opts: opts, dec: dec}
+	scalarDecodersMutex.RLock()
+	if len(scalarDecoders) > 0 {
+		p.decoders = make(map[ValueType]func(string) (string, error), len(scalarDecoders))
+		for kind, fn := range scalarDecoders {
+			p.decoders[kind] = fn
+		}
+	}
+	scalarDecodersMutex.RUnlock()
+	return p
+}
+
+// newScalar creates a text node of the given kind, applying the
+// scalar decoder for kind if there is one. A number, boolean or null
+// whose decoded text is not a valid literal of its kind becomes a
+// string.
+func (p *parser) newScalar(kind ValueType, data string, level int) (*Node, error) {
+	fn := p.opts.ScalarDecoders[kind]
+	if fn == nil {
+		fn = p.decoders[kind]
+	}
+	if fn != nil {
+		var err error
+		if data, err = fn(data); err != nil {
+			return nil, err
+		}
+		switch kind {
+		case NumberValue:
+			if !isJSONNumber(data) {
+				kind = StringValue
+			}
+		case BoolValue:
+			if data != "true" && data != "false" {
+				kind = StringValue
+			}
+		case NullValue:
+			if data != "" {
+				kind = StringValue
+			}
+		}
+	}
+	if kind == StringValue {
+		data = p.intern(data)
+	}
+	n, err := p.newNode(TextNode, data, level)
+	if err != nil {
+		return nil, err
+	}
+	n.ValueType = kind
+	return n, nil
+}
+
+// intern returns the canonical copy of s if InternStrings is set.
+func (p *parser) intern(s string) string {
+	if !p.opts.InternStrings {
+		return s
+	}
+	if v, ok := p.strings[s]; ok {
+		return v
+	}
+	if p.strings == nil {
+		p.strings = make(map[string]string)
+	}
+	p.strings[s] = s
+	return s
+}
+
+// newNode creates a node, enforcing the MaxNodes limit.
+func (p *parser) newNode(typ NodeType, data string, level int) (*Node, error) {
+	p.nodes++
+	if p.opts.MaxNodes > 0 && p.nodes > p.opts.MaxNodes {
+		return nil, fmt.Errorf("jsonquery: document exceeds the maximum of %d nodes", p.opts.MaxNodes)
+	}
+	return &Node{Type: typ, Data: data, level: level}, nil
+}
+
+// appendChild adds n as the last child of top.
+func appendChild(top, n *Node) {
+	n.Parent = top
+	if top.FirstChild == nil {
+		top.FirstChild = n
+	} else {
+		top.LastChild.NextSibling = n
+		n.PrevSibling = top.LastChild
+	}
+	top.LastChild = n
+}
+
+// parseValue reads the next JSON value and adds it to top.
+func (p *parser) parseValue(top *Node, level int) error {
+	tok, err := p.dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return p.parseToken(tok, top, level)
+}
+
+// parseToken adds the JSON value starting with tok to top. Array
+// elements and object members become element nodes at level, and
+// scalars become text nodes.
+func (p *parser) parseToken(tok json.Token, top *Node, level int) error {
+	switch v := tok.(type) {
+	case json.Delim:
+		p.depth++
+		if p.opts.MaxDepth > 0 && p.depth > p.opts.MaxDepth {
+			return fmt.Errorf("jsonquery: document exceeds the maximum depth of %d", p.opts.MaxDepth)
+		}
+		switch v {
+		case '[':
+			top.Container = ArrayContainer
+			for p.dec.More() {
+				n, err := p.newNode(ElementNode, "", level)
+				if err != nil {
+					return err
+				}
+				appendChild(top, n)
+				if err := p.parseValue(n, level+1); err != nil {
+					return err
+				}
+			}
+		case '{':
+			top.Container = ObjectContainer
+			if err := p.parseObject(top, level); err != nil {
+				return err
+			}
+		}
+		// Consume the closing delimiter.
+		if _, err := p.dec.Token(); err != nil {
+			return err
+		}
+		p.depth--
+	case string:
+		n, err := p.newScalar(StringValue, v, level)
+		if err != nil {
+			return err
+		}
+		appendChild(top, n)
+	case json.Number:
+		n, err := p.newScalar(NumberValue, p.formatNumber(v), level)
+		if err != nil {
+			return err
+		}
+		appendChild(top, n)
+	case bool:
+		n, err := p.newScalar(BoolValue, strconv.FormatBool(v), level)
+		if err != nil {
+			return err
+		}
+		appendChild(top, n)
+	case nil:
+		n, err := p.newScalar(NullValue, "", level)
+		if err != nil {
+			return err
+		}
+		appendChild(top, n)
+	}
+	return nil
+}
+
+// parseObject adds the members of an object to top. Unless
+// PreserveOrder is set they are sorted by key. If a key occurs more
+// than once, every occurrence is kept as a separate member unless the
+// options say otherwise.
+func (p *parser) parseObject(top *Node, level int) error {
+	var members []*Node
+	index := make(map[string]int)
+	repeated := make(map[string]bool)
+	for p.dec.More() {
+		tok, err := p.dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		n, err := p.newNode(ElementNode, p.intern(key), level)
+		if err != nil {
+			return err
+		}
+		n.Parent = top
+		if err := p.parseValue(n, level+1); err != nil {
+			return err
+		}
+		i, ok := index[key]
+		switch {
+		case ok && p.opts.RejectRepeatedKeys:
+			return fmt.Errorf("jsonquery: repeated key %q", key)
+		case ok && p.opts.RepeatedKeysAsArray:
+			if !repeated[key] {
+				if err := p.moveIntoItem(members[i], members[i]); err != nil {
+					return err
+				}
+				repeated[key] = true
+			}
+			if err := p.moveIntoItem(members[i], n); err != nil {
+				return err
+			}
+		default:
+			if !ok {
+				index[key] = len(members)
+			}
+			members = append(members, n)
+		}
+	}
+	if !p.opts.PreserveOrder {
+		less := p.opts.KeyLess
+		if less == nil {
+			less = func(a, b string) bool { return a < b }
+		}
+		sort.SliceStable(members, func(i, j int) bool {
+			return less(members[i].Data, members[j].Data)
+		})
+	}
+	top.setChildren(members)
+	if p.opts.IndexKeys {
+		top.keys = make(map[string]*Node, len(members))
+		for _, n := range members {
+			if _, ok := top.keys[n.Data]; !ok {
+				top.keys[n.Data] = n
+			}
+		}
+	}
+	return nil
+}
+
+// moveIntoItem moves the children of src into a new array element
+// appended to dst. dst and src may be the same node.
+func (p *parser) moveIntoItem(dst, src *Node) error {
+	item, err := p.newNode(ElementNode, "", dst.level+1)
+	if err != nil {
+		return err
+	}
+	var children []*Node
+	for child := src.FirstChild; child != nil; child = child.NextSibling {
+		children = append(children, child)
+		shiftLevel(child, 1)
+	}
+	item.setChildren(children)
+	item.Container = src.Container
+	if dst == src {
+		dst.setChildren(nil)
+	}
+	dst.Container = ArrayContainer
+	appendChild(dst, item)
+	return nil
+}
+
+// shiftLevel adds d to the level of n and its descendants.
+func shiftLevel(n *Node, d int) {
+	n.level += d
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		shiftLevel(child, d)
+	}
+}
+
+// document reads a JSON value and returns it as a document tree.
+func (p *parser) document() (*Node, error) {
+	doc := &Node{Type: DocumentNode}
+	if err := p.parseValue(doc, 1); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// end reports an error unless the input has been fully consumed.
+func (p *parser) end() error {
+	_, err := p.dec.Token()
+	if err == io.EOF {
+		return nil
+	}
+	if err == nil {
+		err = errTrailingData
+	}
+	return err
+}
+
+var errTrailingData = errors.New("jsonquery: unexpected data after top-level value")
+
+// ParseError describes malformed JSON found while parsing a document.
+type ParseError struct {
+	// Offset is the number of bytes of the input read when the error
+	// was found, so the offending byte is usually at Offset-1.
+	Offset int64
+
+	// Line and Column give the position of the byte at Offset-1 in
+	// the input, both starting at 1. Column counts runes.
+	Line, Column int
+
+	// Err is the underlying error, such as a *json.SyntaxError or
+	// io.ErrUnexpectedEOF.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("jsonquery: line %d, column %d: %s", e.Line, e.Column, strings.TrimPrefix(e.Err.Error(), "jsonquery: "))
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// newParseError returns a *ParseError for err found after reading
+// offset bytes of b.
+func newParseError(b []byte, offset int64, err error) *ParseError {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	var before []byte
+	if offset > 0 {
+		before = b[:offset-1]
+	}
+	line := bytes.Count(before, []byte{'\n'}) + 1
+	if i := bytes.LastIndexByte(before, '\n'); i >= 0 {
+		before = before[i+1:]
+	}
+	return &ParseError{Offset: offset, Line: line, Column: utf8.RuneCount(before) + 1, Err: err}
+}
+
+func (p *parser) formatNumber(v json.Number) string {
+	if p.opts.NumberFormatter != nil {
+		return p.opts.NumberFormatter(v)
+	}
+	return v.String()
+}
+
+// validateUTF8 reports an error if b contains invalid UTF-8 or a
+// string escape that encodes a lone UTF-16 surrogate.
+func validateUTF8(b []byte) error {
+	inString := false
+	for i := 0; i < len(b); {
+		c := b[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRune(b[i:])
+			if r == utf8.RuneError && size == 1 {
+				return fmt.Errorf("jsonquery: invalid UTF-8 at offset %d", i)
+			}
+			i += size
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = !inString
+		case c == '\\' && inString && i+1 < len(b):
+			if b[i+1] != 'u' {
+				i += 2
+				continue
+			}
+			r, ok := unquoteHex(b[i+2:])
+			if !ok {
+				break
+			}
+			switch {
+			case r >= 0xD800 && r < 0xDC00:
+				if len(b) >= i+8 && b[i+6] == '\\' && b[i+7] == 'u' {
+					if r2, ok := unquoteHex(b[i+8:]); ok && r2 >= 0xDC00 && r2 < 0xE000 {
+						i += 12
+						continue
+					}
+				}
+				return fmt.Errorf("jsonquery: lone surrogate escape at offset %d", i)
+			case r >= 0xDC00 && r < 0xE000:
+				return fmt.Errorf("jsonquery: lone surrogate escape at offset %d", i)
+			}
+			i += 6
+			continue
+		}
+		i++
+	}
+	return nil
+}
+
+// unquoteHex decodes the four hex digits of a \u escape.
+func unquoteHex(b []byte) (rune, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	var r rune
+	for _, c := range b[:4] {
+		switch {
+		case '0' <= c && c <= '9':
+			c = c - '0'
+		case 'a' <= c && c <= 'f':
+			c = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			c = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		r = r*16 + rune(c)
+	}
+	return r, true
+}
+
+// parse builds the tree of the document b. Malformed JSON is reported
+// as a *ParseError.
+func parse(b []byte, opts ParserOptions) (*Node, error) {
+	p := newParser(bytes.NewReader(b), opts)
+	doc, err := p.document()
+	if err != nil {
+		return nil, syntaxError(b, err)
+	}
+	off := p.dec.InputOffset()
+	if err := p.end(); err != nil {
+		if err == errTrailingData {
+			rest := b[off:]
+			off += int64(len(rest)-len(bytes.TrimLeft(rest, " \t\r\n"))) + 1
+			return nil, newParseError(b, off, err)
+		}
+		return nil, syntaxError(b, err)
+	}
+	return doc, nil
+}
+
+// syntaxError returns err as a *ParseError if it reports malformed
+// JSON in b, and err unchanged otherwise.
+func syntaxError(b []byte, err error) error {
+	var offset int64
+	var se *json.SyntaxError
+	switch {
+	case errors.As(err, &se):
+		offset = se.Offset
+	case err == io.ErrUnexpectedEOF:
+		offset = int64(len(b))
+	default:
+		return err
+	}
+	if offset >= int64(len(b)) {
+		// The input ended early. Point at the end of the last value
+		// rather than at any trailing whitespace.
+		offset = int64(len(bytes.TrimRight(b, " \t\r\n")))
+	}
+	return newParseError(b, offset, err)
+}
+
+// Parse JSON document. If the document is not well-formed JSON, the
+// error is a *ParseError giving the position of the problem.
+//
+// The value of the document is held by the document node as the value
+// of a member is held by its element: an object or array gives the
+// document node element children, and a scalar such as 42, "hello",
+// true or null gives it a single text node child. Node.Value returns
+// that text node.
+func Parse(r io.Reader) (*Node, error) {
+	return ParseWithOptions(r, ParserOptions{})
+}
+
+// ParseBytes is like Parse but parses the document held in b, which
+// saves wrapping it in a reader when it is already in memory. The tree
+// does not refer to b, so b may be reused once ParseBytes returns.
+func ParseBytes(b []byte) (*Node, error) {
+	return parse(b, ParserOptions{})
+}
+
+// ParseWithOrder is like Parse but keeps the members of every object,
+// including nested objects and objects inside arrays, in the order they
+// appear in the document instead of sorting them by key. It is a
+// shorthand for ParseWithOptions with PreserveOrder set.
+func ParseWithOrder(r io.Reader) (*Node, error) {
+	return ParseWithOptions(r, ParserOptions{PreserveOrder: true})
+}
+
+// ParseWithOptions is like Parse but allows the parsing behavior to be
+// customized by opts.
+func ParseWithOptions(r io.Reader, opts ParserOptions) (*Node, error) {
+	if opts.Progress != nil {
+		r = &progressReader{r: r, fn: opts.Progress}
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts.StrictUTF8 {
+		if err := validateUTF8(b); err != nil {
+			return nil, err
+		}
+	}
+	return parse(b, opts)
+}
+
+// ParseLines parses r in the JSON Lines (NDJSON) format, in which every
+// line holds a JSON document, and returns one document node per line.
+// Lines that are empty or hold only whitespace are skipped. If a line
+// cannot be parsed, the error reports its 1-based line number; for
+// malformed JSON it is a *ParseError whose position is relative to the
+// whole input.
+func ParseLines(r io.Reader) ([]*Node, error) {
+	br := bufio.NewReader(r)
+	var docs []*Node
+	var offset int64
+	for line := 1; ; line++ {
+		b, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(b)) > 0 {
+			doc, perr := parse(b, ParserOptions{})
+			if pe, ok := perr.(*ParseError); ok {
+				pe.Offset += offset
+				pe.Line += line - 1
+				return nil, pe
+			}
+			if perr != nil {
+				return nil, fmt.Errorf("jsonquery: line %d: %w", line, perr)
+			}
+			docs = append(docs, doc)
+		}
+		if err == io.EOF {
+			return docs, nil
+		}
+		offset += int64(len(b))
+	}
+}
+
+// ParseSlice decodes a top-level JSON array from r directly into dst,
+// which must be a non-nil pointer to a slice, without building a node
+// tree. Prefer it over Parse when the document is a plain array whose
+// values are needed as Go values and no XPath querying is required.
+func ParseSlice(r io.Reader, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("jsonquery: ParseSlice requires a non-nil pointer to a slice, got %T", dst)
+	}
+	return json.NewDecoder(r).Decode(dst)
+}
+
+// ParseDirError is returned by ParseDir and ParseDirRecursive when one
+// or more files cannot be parsed.
+type ParseDirError struct {
+	// Errors maps the name of each file that failed to its error.
+	Errors map[string]error
+}
+
+func (e *ParseDirError) Error() string {
+	var names []string
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	buf.WriteString("jsonquery: failed to parse ")
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		fmt.Fprintf(&buf, "%s: %v", name, e.Errors[name])
+	}
+	return buf.String()
+}
+
+// ParseDir parses every file in dir whose name matches pattern, as
+// defined by filepath.Match, and returns the documents keyed by file
+// name. Subdirectories are skipped. If some files cannot be parsed,
+// the others are still returned along with a *ParseDirError naming
+// the files that failed.
+func ParseDir(dir string, pattern string) (map[string]*Node, error) {
+	return parseDir(dir, pattern, false)
+}
+
+// ParseDirRecursive is like ParseDir but also parses matching files in
+// subdirectories of dir. The documents are keyed by their path relative
+// to dir.
+func ParseDirRecursive(dir string, pattern string) (map[string]*Node, error) {
+	return parseDir(dir, pattern, true)
+}
+
+func parseDir(dir, pattern string, recursive bool) (map[string]*Node, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	docs := make(map[string]*Node)
+	failed := make(map[string]error)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ok, _ := filepath.Match(pattern, info.Name()); !ok {
+			return nil
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		doc, err := parseFile(path)
+		if err != nil {
+			failed[name] = err
+			return nil
+		}
+		docs[name] = doc
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(failed) > 0 {
+		return docs, &ParseDirError{Errors: failed}
+	}
+	return docs, nil
+}
+
+func parseFile(path string) (*Node, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
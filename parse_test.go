@@ -0,0 +1,663 @@
+package jsonquery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestParseStrictUTF8(t *testing.T) {
+	tests := []string{
+		`{"name":"\uDEAD"}`,
+		`{"name":"\uD83D"}`,
+		`{"name":"\uD83Dx"}`,
+		"{\"name\":\"\xff\"}",
+	}
+	for _, s := range tests {
+		doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g := doc.SelectElement("name").InnerText(); !strings.Contains(g, "\uFFFD") {
+			t.Fatalf("expected %q to contain U+FFFD", g)
+		}
+		if _, err := ParseWithOptions(strings.NewReader(s), ParserOptions{StrictUTF8: true}); err == nil {
+			t.Fatalf("expected an error for %q", s)
+		}
+	}
+	doc, err := ParseWithOptions(strings.NewReader(`{"name":"\uD83D\uDE00 \"\\uDEAD\""}`), ParserOptions{StrictUTF8: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "\U0001F600 \"\\uDEAD\"", doc.SelectElement("name").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestParseNumberFormatter(t *testing.T) {
+	opts := ParserOptions{
+		NumberFormatter: func(v json.Number) string {
+			f, _ := v.Float64()
+			return strconv.FormatFloat(f, 'f', 2, 64)
+		},
+	}
+	doc, err := ParseWithOptions(strings.NewReader(`{"price":8.951,"count":3,"name":"7"}`), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "8.95", doc.SelectElement("price").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "3.00", doc.SelectElement("count").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "7", doc.SelectElement("name").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestParseTrailingData(t *testing.T) {
+	for _, s := range []string{``, `{"a":1}}`, `{"a":1} 2`} {
+		if _, err := parseString(s); err == nil {
+			t.Fatalf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	b := []byte(testJSON)
+	doc, err := ParseBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !doc.EqualOrdered(want) {
+		t.Fatalf("expected %v but %v", want.OutputJSON(), doc.OutputJSON())
+	}
+	for i := range b {
+		b[i] = ' '
+	}
+	if e, g := "John", doc.SelectElement("name").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if _, err := ParseBytes([]byte(`{"a":}`)); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, tc := range []struct {
+		s                     string
+		offset                int64
+		line, column          int
+		syntax, unexpectedEOF bool
+	}{
+		{`{"a":x}`, 6, 1, 6, true, false},
+		{"{\n  \"a\": 1,\n  \"b\": tru\n}", 23, 3, 11, true, false},
+		{"[1,\n 2,,\n 3]", 8, 2, 4, true, false},
+		{"{\"név\": \"é\" \"x\"}", 15, 1, 13, true, false},
+		{"{\"a\":\n  [1, 2\n\n", 13, 2, 7, true, false},
+		{"{\"a\":\n", 5, 1, 5, false, true},
+		{"", 0, 1, 1, false, true},
+		{"{} \n  []", 7, 2, 3, false, false},
+	} {
+		_, err := Parse(strings.NewReader(tc.s))
+		pe, ok := err.(*ParseError)
+		if !ok {
+			t.Fatalf("%q: expected a *ParseError but %#v", tc.s, err)
+		}
+		if pe.Offset != tc.offset || pe.Line != tc.line || pe.Column != tc.column {
+			t.Fatalf("%q: expected offset %d line %d column %d but %d %d %d", tc.s, tc.offset, tc.line, tc.column, pe.Offset, pe.Line, pe.Column)
+		}
+		var se *json.SyntaxError
+		if e, g := tc.syntax, errors.As(err, &se); e != g {
+			t.Fatalf("%q: expected %v but %v", tc.s, e, g)
+		}
+		if e, g := tc.unexpectedEOF, errors.Is(err, io.ErrUnexpectedEOF); e != g {
+			t.Fatalf("%q: expected %v but %v", tc.s, e, g)
+		}
+	}
+
+	_, err := Parse(strings.NewReader("[1,\n2 3]"))
+	if e, g := "jsonquery: line 2, column 3: invalid character '3' after array element", fmt.Sprint(err); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	_, err = Parse(strings.NewReader("{}{}"))
+	if e, g := "jsonquery: line 1, column 3: unexpected data after top-level value", fmt.Sprint(err); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	// Other errors are not syntax errors.
+	_, err = ParseWithOptions(strings.NewReader(`[[[1]]]`), ParserOptions{MaxDepth: 2})
+	if _, ok := err.(*ParseError); ok || err == nil {
+		t.Fatalf("expected a depth error but %v", err)
+	}
+
+	_, err = ParseLines(strings.NewReader("{\"a\":1}\n\n{\"a\":}\n"))
+	pe, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected a *ParseError but %#v", err)
+	}
+	if pe.Offset != 15 || pe.Line != 3 || pe.Column != 6 {
+		t.Fatalf("expected offset 15 line 3 column 6 but %d %d %d", pe.Offset, pe.Line, pe.Column)
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	s := "{\"level\":\"info\",\"msg\":\"start\"}\n\n  \r\n{\"level\":\"error\",\"msg\":\"fail\"}\r\n[1,2]"
+	docs, err := ParseLines(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 3, len(docs); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	var levels []string
+	for _, doc := range docs[:2] {
+		levels = append(levels, FindOne(doc, "level").InnerText())
+	}
+	if e, g := "info,error", strings.Join(levels, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := `[1,2]`, docs[2].OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	_, err = ParseLines(strings.NewReader("{\"a\":1}\n\n{\"a\":\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("expected an error on line 3 but %v", err)
+	}
+	if docs, err := ParseLines(strings.NewReader("")); err != nil || len(docs) != 0 {
+		t.Fatalf("expected no documents but %v, %v", len(docs), err)
+	}
+}
+
+func TestParseSlice(t *testing.T) {
+	var ints []int
+	if err := ParseSlice(strings.NewReader(`[1,2,3]`), &ints); err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "[1 2 3]", fmt.Sprint(ints); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	var names []string
+	if err := ParseSlice(strings.NewReader(`["Ford","BMW"]`), &names); err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "Ford,BMW", strings.Join(names, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if err := ParseSlice(strings.NewReader(`{"a":1}`), &ints); err == nil {
+		t.Fatal("expected an error for an object document")
+	}
+	if err := ParseSlice(strings.NewReader(`[1]`), ints); err == nil {
+		t.Fatal("expected an error for a non-pointer destination")
+	}
+}
+
+func TestParseValueType(t *testing.T) {
+	doc, err := parseString(`{"name":"John","age":30,"motorist":true,"spouse":null,"code":"30","flag":"true",
+		"cars":[{"name":"BMW","models":["320","X3"]}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		expr string
+		typ  ValueType
+	}{
+		{"name/text()", StringValue},
+		{"age/text()", NumberValue},
+		{"motorist/text()", BoolValue},
+		{"spouse/text()", NullValue},
+		{"code/text()", StringValue},
+		{"flag/text()", StringValue},
+		{"cars/*[1]/models/*[1]/text()", StringValue},
+	}
+	for _, v := range expected {
+		if g := doc.SelectElement(v.expr).ValueType; g != v.typ {
+			t.Fatalf("%v: expected %v but %v", v.expr, v.typ, g)
+		}
+	}
+	// Queries compare the text regardless of the type.
+	for _, expr := range []string{"age[.=30]", "code[.=30]", "motorist[.='true']", "flag[.='true']"} {
+		if doc.SelectElement(expr) == nil {
+			t.Fatalf("%v: expected a match", expr)
+		}
+	}
+}
+
+func TestValueTypeString(t *testing.T) {
+	for typ, e := range map[ValueType]string{
+		StringValue:  "string",
+		NumberValue:  "number",
+		BoolValue:    "boolean",
+		NullValue:    "null",
+		ValueType(9): "ValueType(9)",
+	} {
+		if g := typ.String(); e != g {
+			t.Fatalf("expected %v but %v", e, g)
+		}
+	}
+}
+
+func TestParseMaxNodes(t *testing.T) {
+	s := `[[],[],[],[],[]]`
+	if _, err := ParseWithOptions(strings.NewReader(s), ParserOptions{MaxNodes: 5}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ParseWithOptions(strings.NewReader(s), ParserOptions{MaxNodes: 4})
+	if err == nil {
+		t.Fatal("expected an error when the node limit is exceeded")
+	}
+	if !strings.Contains(err.Error(), "maximum of 4 nodes") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// {"name":"John"} has an element and a text node.
+	if _, err := ParseWithOptions(strings.NewReader(`{"name":"John"}`), ParserOptions{MaxNodes: 1}); err == nil {
+		t.Fatal("expected an error when the node limit is exceeded")
+	}
+}
+
+func TestParseMaxDepth(t *testing.T) {
+	for _, tc := range []struct {
+		s     string
+		depth int
+		ok    bool
+	}{
+		{`{"a":[1]}`, 2, true},
+		{`{"a":[1]}`, 1, false},
+		{`[[],[],{"a":{}}]`, 3, true},
+		{`[[],[],{"a":{}}]`, 2, false},
+		{`"scalar"`, 1, true},
+		{strings.Repeat("[", 5000) + strings.Repeat("]", 5000), 100, false},
+		{strings.Repeat("[", 5000) + strings.Repeat("]", 5000), 0, true},
+	} {
+		_, err := ParseWithOptions(strings.NewReader(tc.s), ParserOptions{MaxDepth: tc.depth})
+		if tc.ok && err != nil {
+			t.Fatalf("%.20v with %v: %v", tc.s, tc.depth, err)
+		}
+		if !tc.ok && (err == nil || !strings.Contains(err.Error(), "maximum depth")) {
+			t.Fatalf("%.20v with %v: expected a depth error but %v", tc.s, tc.depth, err)
+		}
+	}
+}
+
+func TestParseInternStrings(t *testing.T) {
+	s := `[{"color":"red"},{"color":"red"}]`
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{InternStrings: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := doc.SelectElements("*/color")
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes but %v", len(nodes))
+	}
+	a, b := nodes[0].FirstChild.Data, nodes[1].FirstChild.Data
+	if a != "red" || b != "red" {
+		t.Fatalf("unexpected values %v, %v", a, b)
+	}
+	if (*reflect.StringHeader)(unsafe.Pointer(&a)).Data != (*reflect.StringHeader)(unsafe.Pointer(&b)).Data {
+		t.Fatal("expected interned values to share storage")
+	}
+}
+
+func benchmarkParseRetained(b *testing.B, opts ParserOptions) {
+	var buf strings.Builder
+	buf.WriteString("[")
+	for i := 0; i < 10000; i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, `{"status":"active","category":"category-%d","region":"europe-west"}`, i%5)
+	}
+	buf.WriteString("]")
+	s := buf.String()
+	b.ReportAllocs()
+	var retained uint64
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		doc, err := ParseWithOptions(strings.NewReader(s), opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		retained += after.HeapAlloc - before.HeapAlloc
+		runtime.KeepAlive(doc)
+	}
+	b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+}
+
+func BenchmarkParseRepetitive(b *testing.B) {
+	benchmarkParseRetained(b, ParserOptions{})
+}
+
+func BenchmarkParseRepetitiveInterned(b *testing.B) {
+	benchmarkParseRetained(b, ParserOptions{InternStrings: true})
+}
+
+func TestParseProgress(t *testing.T) {
+	s := "[" + strings.Repeat(`"abcdefghijklmnopqrstuvwxyz",`, 10000) + `""]`
+	var calls []int64
+	opts := ParserOptions{
+		Progress: func(n int64) {
+			calls = append(calls, n)
+		},
+	}
+	if _, err := ParseWithOptions(strings.NewReader(s), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) == 0 {
+		t.Fatal("expected progress to be reported")
+	}
+	if max := len(s)/progressInterval + 1; len(calls) > max {
+		t.Fatalf("expected at most %v calls but %v", max, len(calls))
+	}
+	for i := 1; i < len(calls); i++ {
+		if calls[i] <= calls[i-1] {
+			t.Fatalf("progress went backwards: %v", calls)
+		}
+	}
+	if e, g := int64(len(s)), calls[len(calls)-1]; e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestParsePreserveOrder(t *testing.T) {
+	opts := ParserOptions{PreserveOrder: true, IndexKeys: true}
+	doc, err := ParseWithOptions(strings.NewReader(testJSON), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, n := range doc.ChildNodes() {
+		keys = append(keys, n.Data)
+	}
+	if e, g := "name,age,motorist,cars", strings.Join(keys, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	keys = keys[:0]
+	for _, n := range doc.SelectElement("cars/*[1]").ChildNodes() {
+		keys = append(keys, n.Data)
+	}
+	if e, g := "name,models", strings.Join(keys, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if doc.keys == nil || doc.SelectElement("cars/*[1]").keys == nil {
+		t.Fatal("expected objects to be indexed")
+	}
+	if n := doc.SelectElementByKey("age"); n == nil || n.InnerText() != "30" {
+		t.Fatal("expected to find age by key")
+	}
+	if n := doc.SelectElement("cars/*[3]").SelectElementByKey("name"); n == nil || n.InnerText() != "Fiat" {
+		t.Fatal("expected to find name by key")
+	}
+	if n := doc.SelectElementByKey("city"); n != nil {
+		t.Fatal("expected no match")
+	}
+}
+
+func TestParseWithOrder(t *testing.T) {
+	s := `{"z":{"b":1,"a":{"y":2,"x":3}},"m":[{"d":4,"c":5}]}`
+	doc, err := ParseWithOrder(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := doc.OutputJSONDepth(0); s != g {
+		t.Fatalf("expected %v but %v", s, g)
+	}
+	if e, g := `<?xml version="1.0"?><z><b>1</b><a><y>2</y><x>3</x></a></z><m><element><d>4</d><c>5</c></element></m>`, doc.OutputXML(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestParseRepeatedKeys(t *testing.T) {
+	s := `{"b":1,"a":{"x":"first"},"b":3,"a":{"x":"second"}}`
+	for _, opts := range []ParserOptions{
+		{},
+		{IndexKeys: true},
+	} {
+		doc, err := ParseWithOptions(strings.NewReader(s), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, g := `<?xml version="1.0"?><a><x>first</x></a><a><x>second</x></a><b>1</b><b>3</b>`, doc.OutputXML(); e != g {
+			t.Fatalf("expected %v but %v", e, g)
+		}
+		var xs []string
+		for _, n := range doc.SelectElements("//a/x") {
+			xs = append(xs, n.InnerText())
+		}
+		if e, g := "first,second", strings.Join(xs, ","); e != g {
+			t.Fatalf("expected %v but %v", e, g)
+		}
+		if e, g := "first", doc.SelectElementByKey("a").SelectElement("x").InnerText(); e != g {
+			t.Fatalf("expected %v but %v", e, g)
+		}
+		if err := doc.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{PreserveOrder: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := s, doc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	doc, err = ParseWithOptions(strings.NewReader(`{"b":1,"a":2,"b":3}`), ParserOptions{PreserveOrder: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := `{"b":1,"a":2,"b":3}`, doc.OutputJSONDepth(0); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestParseRejectRepeatedKeys(t *testing.T) {
+	for _, opts := range []ParserOptions{
+		{RejectRepeatedKeys: true},
+		{RejectRepeatedKeys: true, RepeatedKeysAsArray: true},
+	} {
+		_, err := ParseWithOptions(strings.NewReader(`{"a":[{"b":1,"c":2,"b":3}]}`), opts)
+		if e, g := `jsonquery: repeated key "b"`, fmt.Sprint(err); e != g {
+			t.Fatalf("expected %v but %v", e, g)
+		}
+	}
+	if _, err := ParseWithOptions(strings.NewReader(`{"a":{"b":1},"b":{"a":2}}`), ParserOptions{RejectRepeatedKeys: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseRepeatedKeysAsArray(t *testing.T) {
+	s := `{"tag":"a","id":1,"tag":{"x":"b"},"tag":"c"}`
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{RepeatedKeysAsArray: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := `{"id":1,"tag":["a",{"x":"b"},"c"]}`, doc.OutputJSONDepth(0); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	x := FindOne(doc, "tag/*[2]/x")
+	if e, g := "b", x.InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 3, x.level; e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if err := doc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterScalarDecoder(t *testing.T) {
+	RegisterScalarDecoder(StringValue, func(raw string) (string, error) {
+		b, err := base64.StdEncoding.DecodeString(raw)
+		return string(b), err
+	})
+	RegisterScalarDecoder(BoolValue, func(raw string) (string, error) {
+		return strings.ToUpper(raw), nil
+	})
+	RegisterScalarDecoder(NumberValue, func(raw string) (string, error) {
+		if raw == "30" {
+			return "3e1", nil
+		}
+		return raw + " units", nil
+	})
+	defer RegisterScalarDecoder(StringValue, nil)
+	defer RegisterScalarDecoder(BoolValue, nil)
+	defer RegisterScalarDecoder(NumberValue, nil)
+
+	doc, err := parseString(`{"name":"Sm9obg==","age":30,"height":180,"motorist":true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Results that are not valid literals of their kind become strings.
+	for key, e := range map[string]ValueType{"name": StringValue, "age": NumberValue, "height": StringValue, "motorist": StringValue} {
+		if g := doc.SelectElement(key + "/text()").ValueType; e != g {
+			t.Fatalf("%s: expected %v but %v", key, e, g)
+		}
+	}
+	out := doc.OutputJSONDepth(0)
+	if e := `{"age":3e1,"height":"180 units","motorist":"TRUE","name":"John"}`; e != out {
+		t.Fatalf("expected %v but %v", e, out)
+	}
+	if !json.Valid([]byte(out)) {
+		t.Fatalf("expected valid JSON but %v", out)
+	}
+	if _, err := parseString(`{"name":"not base64"}`); err == nil {
+		t.Fatal("expected the decoder error to abort parsing")
+	}
+
+	// A decoder in the options takes precedence for this parse only.
+	doc, err = ParseWithOptions(strings.NewReader(`{"name":"Sm9obg==","motorist":false,"spouse":null}`), ParserOptions{
+		ScalarDecoders: map[ValueType]func(string) (string, error){
+			StringValue: func(raw string) (string, error) { return strings.ToLower(raw), nil },
+			NullValue:   func(raw string) (string, error) { return "none", nil },
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := `{"motorist":"FALSE","name":"sm9obg==","spouse":"none"}`, doc.OutputJSON(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	RegisterScalarDecoder(StringValue, nil)
+	doc, err = parseString(`{"name":"Sm9obg=="}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "Sm9obg==", doc.SelectElement("name").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestParseKeyLess(t *testing.T) {
+	s := `{"b":1,"C":2,"a":3,"B":4}`
+	keys := func(doc *Node) string {
+		var a []string
+		for _, n := range doc.ChildNodes() {
+			a = append(a, n.Data)
+		}
+		return strings.Join(a, ",")
+	}
+	doc, err := parseString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "B,C,a,b", keys(doc); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	opts := ParserOptions{
+		KeyLess: func(a, b string) bool {
+			return strings.ToLower(a) < strings.ToLower(b)
+		},
+	}
+	doc, err = ParseWithOptions(strings.NewReader(s), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "a,b,B,C", keys(doc); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestParseDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonquery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"a.json":        `{"name":"a"}`,
+		"b.json":        `{"name":"b"}`,
+		"broken.json":   `{"name":`,
+		"notes.txt":     `not json`,
+		"sub/c.json":    `{"name":"c"}`,
+		"sub/bad.json":  `[1,`,
+		"sub/notes.txt": `not json`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	docs, err := ParseDir(dir, "*.json")
+	perr, ok := err.(*ParseDirError)
+	if !ok {
+		t.Fatalf("expected a *ParseDirError but %v", err)
+	}
+	if len(perr.Errors) != 1 || perr.Errors["broken.json"] == nil {
+		t.Fatalf("unexpected errors %v", perr.Errors)
+	}
+	if !strings.Contains(err.Error(), "broken.json") {
+		t.Fatalf("error should name the failed file: %v", err)
+	}
+	if len(docs) != 2 || docs["a.json"].SelectElement("name").InnerText() != "a" || docs["b.json"] == nil {
+		t.Fatalf("unexpected documents %v", docs)
+	}
+
+	docs, err = ParseDirRecursive(dir, "*.json")
+	perr, ok = err.(*ParseDirError)
+	if !ok || len(perr.Errors) != 2 {
+		t.Fatalf("expected two failed files but %v", err)
+	}
+	if _, ok := perr.Errors[filepath.Join("sub", "bad.json")]; !ok {
+		t.Fatalf("unexpected errors %v", perr.Errors)
+	}
+	if n := docs[filepath.Join("sub", "c.json")]; n == nil || n.SelectElement("name").InnerText() != "c" {
+		t.Fatalf("unexpected documents %v", docs)
+	}
+
+	docs, err = ParseDir(dir, "[ab].json")
+	if err != nil || len(docs) != 2 {
+		t.Fatalf("expected two documents but %v, %v", len(docs), err)
+	}
+	if _, err := ParseDir(dir, "["); err == nil {
+		t.Fatal("expected an error for a malformed pattern")
+	}
+	if _, err := ParseDir(filepath.Join(dir, "missing"), "*.json"); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+}
@@ -1,7 +1,13 @@
 package jsonquery
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/antchfx/xpath"
 )
@@ -13,7 +19,7 @@ func CreateXPathNavigator(top *Node) *NodeNavigator {
 	return &NodeNavigator{cur: top, root: top}
 }
 
-// Find is like QueryAll but will panics if `expr` cannot be parsed.
+// Find is like QueryAll but will panics if `expr` cannot be parsed or evaluated.
 func Find(top *Node, expr string) []*Node {
 	nodes, err := QueryAll(top, expr)
 	if err != nil {
@@ -22,7 +28,7 @@ func Find(top *Node, expr string) []*Node {
 	return nodes
 }
 
-// FindOne is like Query but will panics if `expr` cannot be parsed.
+// FindOne is like Query but will panics if `expr` cannot be parsed or evaluated.
 func FindOne(top *Node, expr string) *Node {
 	node, err := Query(top, expr)
 	if err != nil {
@@ -31,14 +37,61 @@ func FindOne(top *Node, expr string) *Node {
 	return node
 }
 
+// FindEach calls fn for every Node matching expr, in document order,
+// with the 0-based index of the match, without collecting the matches
+// into a slice. Like Find, it panics if expr cannot be parsed or
+// evaluated; Node.ForEachMatch does the same walk but returns the error.
+func FindEach(top *Node, expr string, fn func(i int, n *Node)) {
+	FindEachWithBreak(top, expr, func(i int, n *Node) bool {
+		fn(i, n)
+		return true
+	})
+}
+
+// FindEachWithBreak is like FindEach but stops as soon as fn returns
+// false. It is a wrapper around top.ForEachMatch.
+func FindEachWithBreak(top *Node, expr string, fn func(i int, n *Node) bool) {
+	i := 0
+	err := top.ForEachMatch(expr, func(n *Node) error {
+		if !fn(i, n) {
+			return errStop
+		}
+		i++
+		return nil
+	})
+	if err != nil && err != errStop {
+		panic(err)
+	}
+}
+
 // QueryAll searches the Node that matches by the specified XPath expr.
-// Return an error if the expression `expr` cannot be parsed.
+// Return an error if the expression `expr` cannot be parsed or evaluated.
 func QueryAll(top *Node, expr string) ([]*Node, error) {
 	exp, err := getQuery(expr)
 	if err != nil {
 		return nil, err
 	}
-	return QuerySelectorAll(top, exp), nil
+	elems, err := selectAll(context.Background(), top, exp, 0)
+	if err != nil {
+		return nil, err
+	}
+	return elems, nil
+}
+
+// QueryAllN is like QueryAll but returns at most max matches, the
+// first in document order, and stops evaluating expr once it has found
+// them, which saves walking the rest of a large document. If max is
+// not positive, all matches are returned.
+func QueryAllN(top *Node, expr string, max int) ([]*Node, error) {
+	exp, err := getQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	elems, err := selectAll(context.Background(), top, exp, max)
+	if err != nil {
+		return nil, err
+	}
+	return elems, nil
 }
 
 // Query searches the Node that matches by the specified XPath expr,
@@ -48,7 +101,31 @@ func Query(top *Node, expr string) (*Node, error) {
 	if err != nil {
 		return nil, err
 	}
-	return QuerySelector(top, exp), nil
+	elems, err := selectAll(context.Background(), top, exp, 1)
+	if err != nil || len(elems) == 0 {
+		return nil, err
+	}
+	return elems[0], nil
+}
+
+// Compile compiles expr for use with QuerySelector and QuerySelectorAll.
+// Compiling an expression once and reusing it is the fastest way to run
+// the same query many times, since it skips the selector cache lookup
+// the other query functions make. Like them, it supports id() with the
+// key set by SetIDKey at the time of the call.
+func Compile(expr string) (*xpath.Expr, error) {
+	return xpath.Compile(rewriteContextCalls(rewriteIDCalls(expr)))
+}
+
+// MustCompile is like Compile but panics if expr cannot be parsed. It
+// simplifies initializing package variables holding compiled
+// expressions.
+func MustCompile(expr string) *xpath.Expr {
+	exp, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return exp
 }
 
 // QuerySelectorAll searches all of the Node that matches the specified XPath selectors.
@@ -61,6 +138,57 @@ func QuerySelectorAll(top *Node, selector *xpath.Expr) []*Node {
 	return elems
 }
 
+// selectAll returns the nodes matched by selector, stopping after limit
+// matches if limit is positive. ctx is checked before each match; if it
+// is done, the matches found so far are returned along with ctx.Err().
+func selectAll(ctx context.Context, top *Node, selector *xpath.Expr, limit int) ([]*Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var elems []*Node
+	err := eachMatch(top, selector, func(n *Node) error {
+		elems = append(elems, n)
+		if limit > 0 && len(elems) >= limit {
+			return errStop
+		}
+		return ctx.Err()
+	})
+	if err == errStop {
+		err = nil
+	}
+	return elems, err
+}
+
+// errStop is returned by the callback of eachMatch to stop early.
+var errStop = errors.New("jsonquery: stop")
+
+// eachMatch calls fn for every node matched by selector in document
+// order, stopping at and returning the first error fn returns. The
+// XPath engine panics when it cannot convert a value to a number, for
+// example in string(.) > 1 where the string is not a number; such a
+// panic is returned as an error rather than crashing the caller.
+func eachMatch(top *Node, selector *xpath.Expr, fn func(*Node) error) (err error) {
+	inFn := false
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*strconv.NumError)
+			if inFn || !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("jsonquery: cannot evaluate expression: %w", e)
+		}
+	}()
+	t := selector.Select(CreateXPathNavigator(top))
+	for t.MoveNext() {
+		inFn = true
+		if err := fn(t.Current().(*NodeNavigator).cur); err != nil {
+			return err
+		}
+		inFn = false
+	}
+	return nil
+}
+
 // QuerySelector returns the first matched JSON Node by the specified XPath selector.
 func QuerySelector(top *Node, selector *xpath.Expr) *Node {
 	t := selector.Select(CreateXPathNavigator(top))
@@ -70,6 +198,218 @@ func QuerySelector(top *Node, selector *xpath.Expr) *Node {
 	return nil
 }
 
+var (
+	idKey      string
+	idKeyMutex sync.RWMutex
+)
+
+// SetIDKey sets the name of the object key whose value identifies an
+// element for the XPath id() function, so that with SetIDKey("name")
+// the expression id('BMW') selects every element with a name child
+// whose value is BMW. An empty key, the default, disables id().
+//
+// JSON has no DTD to declare IDs, so id() is implemented by rewriting
+// the expression before it is compiled. Only string literal arguments
+// are supported; like XPath, a literal holding several whitespace
+// separated IDs matches any of them. The values are not required to
+// be unique.
+func SetIDKey(key string) {
+	idKeyMutex.Lock()
+	idKey = key
+	idKeyMutex.Unlock()
+}
+
+// rewriteIDCalls replaces calls to id() with a string literal argument
+// by an equivalent path selecting the elements whose key child equals
+// one of the given IDs.
+func rewriteIDCalls(expr string) string {
+	idKeyMutex.RLock()
+	key := idKey
+	idKeyMutex.RUnlock()
+	if key == "" || !strings.Contains(expr, "id") {
+		return expr
+	}
+	var b strings.Builder
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		if c == '\'' || c == '"' {
+			j := strings.IndexByte(expr[i+1:], c)
+			if j < 0 {
+				b.WriteString(expr[i:])
+				break
+			}
+			b.WriteString(expr[i : i+j+2])
+			i += j + 2
+			continue
+		}
+		if c == 'i' && strings.HasPrefix(expr[i:], "id") && (i == 0 || !isNameChar(expr[i-1])) {
+			rest := strings.TrimLeft(expr[i+2:], " \t\r\n")
+			if strings.HasPrefix(rest, "(") {
+				arg := strings.TrimLeft(rest[1:], " \t\r\n")
+				if len(arg) > 0 && (arg[0] == '\'' || arg[0] == '"') {
+					q := arg[0]
+					if j := strings.IndexByte(arg[1:], q); j >= 0 {
+						tail := strings.TrimLeft(arg[j+2:], " \t\r\n")
+						if strings.HasPrefix(tail, ")") {
+							b.WriteString(idPath(key, strings.Fields(arg[1:j+1])))
+							i = len(expr) - len(tail) + 1
+							continue
+						}
+					}
+				}
+			}
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String()
+}
+
+// isNameChar reports whether c may be part of an XPath name, variable
+// reference or attribute axis abbreviation.
+func isNameChar(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || c == ':' || c == '@' || c == '$' ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// contextFunctions are the XPath functions whose argument defaults to
+// the context node but that the xpath package requires an argument for.
+var contextFunctions = []string{"normalize-space", "string-length"}
+
+// rewriteContextCalls replaces calls without arguments to the functions
+// in contextFunctions by calls with the context node, ".", so that
+// //name[normalize-space()='John'] works as in XPath 1.0.
+func rewriteContextCalls(expr string) string {
+	if !strings.Contains(expr, "-") {
+		return expr
+	}
+	var b strings.Builder
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		if c == '\'' || c == '"' {
+			j := strings.IndexByte(expr[i+1:], c)
+			if j < 0 {
+				b.WriteString(expr[i:])
+				break
+			}
+			b.WriteString(expr[i : i+j+2])
+			i += j + 2
+			continue
+		}
+		if i == 0 || !isNameChar(expr[i-1]) {
+			rewritten := false
+			for _, name := range contextFunctions {
+				if !strings.HasPrefix(expr[i:], name) {
+					continue
+				}
+				rest := strings.TrimLeft(expr[i+len(name):], " \t\r\n")
+				if !strings.HasPrefix(rest, "(") {
+					continue
+				}
+				tail := strings.TrimLeft(rest[1:], " \t\r\n")
+				if strings.HasPrefix(tail, ")") {
+					b.WriteString(name + "(.)")
+					i = len(expr) - len(tail) + 1
+					rewritten = true
+					break
+				}
+			}
+			if rewritten {
+				continue
+			}
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String()
+}
+
+// idPath returns a path that selects the elements with a child named
+// key whose value is one of ids.
+func idPath(key string, ids []string) string {
+	if len(ids) == 0 {
+		return "(//*[false()])"
+	}
+	name := quoteLiteral(key)
+	var conds []string
+	for _, id := range ids {
+		conds = append(conds, "*[name()="+name+"]="+quoteLiteral(id))
+	}
+	return "(//*[" + strings.Join(conds, " or ") + "])"
+}
+
+// quoteLiteral quotes s as an XPath string literal. XPath 1.0 has no
+// escapes, so s cannot contain both quote characters.
+func quoteLiteral(s string) string {
+	if strings.Contains(s, "'") {
+		return `"` + s + `"`
+	}
+	return "'" + s + "'"
+}
+
+// Union returns the nodes that are in a or b, without duplicates, in
+// document order. Nodes are compared by identity and are expected to
+// belong to the same tree.
+func Union(a, b []*Node) []*Node {
+	seen := make(map[*Node]bool)
+	var nodes []*Node
+	for _, list := range [][]*Node{a, b} {
+		for _, n := range list {
+			if !seen[n] {
+				seen[n] = true
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	sortDocumentOrder(nodes)
+	return nodes
+}
+
+// Intersect returns the nodes that are in both a and b, without
+// duplicates, in document order. Nodes are compared by identity and
+// are expected to belong to the same tree.
+func Intersect(a, b []*Node) []*Node {
+	inB := make(map[*Node]bool)
+	for _, n := range b {
+		inB[n] = true
+	}
+	seen := make(map[*Node]bool)
+	var nodes []*Node
+	for _, n := range a {
+		if inB[n] && !seen[n] {
+			seen[n] = true
+			nodes = append(nodes, n)
+		}
+	}
+	sortDocumentOrder(nodes)
+	return nodes
+}
+
+// sortDocumentOrder sorts nodes in the order they appear in their tree,
+// with ancestors before their descendants.
+func sortDocumentOrder(nodes []*Node) {
+	positions := make(map[*Node][]int, len(nodes))
+	for _, n := range nodes {
+		var pos []int
+		for m := n; m.Parent != nil; m = m.Parent {
+			pos = append(pos, m.IndexInParent())
+		}
+		for i, j := 0, len(pos)-1; i < j; i, j = i+1, j-1 {
+			pos[i], pos[j] = pos[j], pos[i]
+		}
+		positions[n] = pos
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := positions[nodes[i]], positions[nodes[j]]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+}
+
 // NodeNavigator is for navigating JSON document.
 type NodeNavigator struct {
 	root, cur *Node
@@ -101,6 +441,12 @@ func (a *NodeNavigator) Prefix() string {
 	return ""
 }
 
+// Value returns the text of the current node. Numbers keep the text
+// they were written with, which the XPath engine converts when they
+// are compared with numbers, so //age[. > 18] compares by value. The
+// engine cannot convert text that is not a number, so compare nodes
+// that may hold other values through number(), as in
+// //*[number(.) > 100].
 func (a *NodeNavigator) Value() string {
 	switch a.cur.Type {
 	case ElementNode:
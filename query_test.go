@@ -16,11 +16,83 @@ func BenchmarkSelectorCache(b *testing.B) {
 
 func BenchmarkDisableSelectorCache(b *testing.B) {
 	DisableSelectorCache = true
+	defer func() { DisableSelectorCache = false }()
 	for i := 0; i < b.N; i++ {
 		getQuery("/AAA/BBB/DDD/CCC/EEE/ancestor::*")
 	}
 }
 
+func TestSelectorCache(t *testing.T) {
+	defer func(max int) {
+		SelectorCacheMaxEntries = max
+		ClearSelectorCache()
+	}(SelectorCacheMaxEntries)
+	SelectorCacheMaxEntries = 2
+	ClearSelectorCache()
+
+	a, err := getQuery("//a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := getQuery("//a"); a != b {
+		t.Fatal("expected the compiled expression to be reused")
+	}
+	if _, err := getQuery("//a["); err == nil {
+		t.Fatal("expected a compilation error")
+	}
+	if _, err := getQuery("//a["); err == nil {
+		t.Fatal("expected the compilation error again")
+	}
+	getQuery("//b")
+	getQuery("//c")
+	if e, g := 2, cache.Len(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	ClearSelectorCache()
+	if b, _ := getQuery("//a"); a == b {
+		t.Fatal("expected a new compiled expression after clearing the cache")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, err := Compile("//cars/*["); err == nil {
+		t.Fatalf("expected an error but %v", exp)
+	}
+	exp, err := Compile("models/*[last()]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last []string
+	for _, car := range Find(doc, "//cars/*") {
+		last = append(last, QuerySelector(car, exp).InnerText())
+	}
+	if e, g := "Mustang,X5,Panda", strings.Join(last, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 8, len(QuerySelectorAll(doc, MustCompile("//models/*"))); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	// Compiled expressions support the rewritten functions.
+	SetIDKey("name")
+	exp = MustCompile("id('BMW')/models/*[normalize-space()='X3']")
+	SetIDKey("")
+	if n := QuerySelector(doc, exp); n == nil || n.InnerText() != "X3" {
+		t.Fatalf("expected X3 but %v", n)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected MustCompile to panic")
+		}
+	}()
+	MustCompile("//a[")
+}
+
 func TestNavigator(t *testing.T) {
 	s := `{
 		"name":"John",
@@ -131,3 +203,387 @@ func TestNavigator(t *testing.T) {
 		t.Fatalf("node type is not DocumentNode")
 	}
 }
+
+func TestQueryAxesFromElement(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x3 := FindOne(doc, "//models/*[.='X3']")
+	if x3 == nil {
+		t.Fatal("x3 is nil")
+	}
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		{"parent::*", []string{"models"}},
+		{"..", []string{"models"}},
+		{"ancestor::cars", []string{"cars"}},
+		{"ancestor::*", []string{"models", "", "cars"}},
+		{"following-sibling::*", []string{""}},
+		{"preceding-sibling::*", []string{""}},
+		{"../../name", []string{"name"}},
+		{"ancestor::cars/preceding-sibling::*", []string{"age"}},
+	}
+	for _, test := range tests {
+		nodes, err := QueryAll(x3, test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, n := range nodes {
+			names = append(names, n.Data)
+		}
+		if e, g := strings.Join(test.expected, ","), strings.Join(names, ","); e != g {
+			t.Fatalf("%v: expected %v but %v", test.expr, e, g)
+		}
+	}
+	if e, g := "X5", FindOne(x3, "following-sibling::*").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "320", FindOne(x3, "preceding-sibling::*").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "BMW", FindOne(x3, "ancestor::*[models]/name").InnerText(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestIDFunction(t *testing.T) {
+	SetIDKey("name")
+	defer SetIDKey("")
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := FindOne(doc, "id('BMW')")
+	if n == nil {
+		t.Fatal("n is nil")
+	}
+	if n != FindOne(doc, "cars/*[2]") {
+		t.Fatal("id('BMW') should select the second car")
+	}
+	var models []string
+	for _, n := range Find(doc, `id("BMW")/models/*`) {
+		models = append(models, n.InnerText())
+	}
+	if e, g := "320,X3,X5", strings.Join(models, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 2, len(Find(doc, "id('Ford Fiat')")); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if n := FindOne(doc, "id('Audi')"); n != nil {
+		t.Fatal("expected no match")
+	}
+	if n := FindOne(doc, "cars/*[name='id(\"BMW\")']"); n != nil {
+		t.Fatal("id() inside a string literal should not be rewritten")
+	}
+	SetIDKey("")
+	if _, err := QueryAll(doc, "id('BMW')"); err == nil {
+		t.Fatal("expected an error when no ID key is set")
+	}
+}
+
+func TestUnionIntersect(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := func(nodes []*Node) string {
+		var a []string
+		for _, n := range nodes {
+			a = append(a, n.InnerText())
+		}
+		return strings.Join(a, ",")
+	}
+	// Models of cars named BMW or Fiat, and models starting with an X or 5.
+	a := Find(doc, "//cars/*[name='BMW' or name='Fiat']/models/*")
+	b := Find(doc, "//models/*[starts-with(., 'X') or starts-with(., '5')]")
+	// Pass b in reverse order to check that the result is sorted.
+	reversed := []*Node{b[2], b[1], b[0]}
+	if e, g := "320,X3,X5,500,Panda", texts(Union(reversed, a)); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "X3,X5,500", texts(Intersect(a, reversed)); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	cars := FindOne(doc, "cars")
+	if e, g := 2, len(Union([]*Node{cars.FirstChild, cars}, []*Node{cars})); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if n := Union([]*Node{cars.FirstChild}, []*Node{cars}); n[0] != cars {
+		t.Fatal("ancestors should come before their descendants")
+	}
+	if n := Intersect(a, nil); len(n) != 0 {
+		t.Fatalf("expected no nodes but %v", len(n))
+	}
+}
+
+func TestNormalizeSpace(t *testing.T) {
+	doc, err := parseString(`{"people":[{"name":"  John   Smith "},{"name":"Jane"}],"tags":[" a  b ","c"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		expr string
+		e    int
+	}{
+		{"//name[normalize-space(.)='John Smith']", 1},
+		{"//people/*[normalize-space(name)='John Smith']", 1},
+		{"//name[normalize-space()='John Smith']", 1},
+		{"//name[normalize-space( )='John Smith']", 1},
+		{"//tags/*[normalize-space()='a b']", 1},
+		{"//name[.='John Smith']", 0},
+		{"//tags/*[string-length()=1]", 1},
+		{"//name[normalize-space()='normalize-space()']", 0},
+	} {
+		if g := len(Find(doc, tc.expr)); tc.e != g {
+			t.Fatalf("%v: expected %v but %v", tc.expr, tc.e, g)
+		}
+	}
+}
+
+func TestPositionalPredicates(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		expr, e string
+	}{
+		{"/cars/*[1]/name", "Ford"},
+		{"/cars/*[2]/name", "BMW"},
+		{"/cars/*[3]/name", "Fiat"},
+		{"/cars/*[last()]/name", "Fiat"},
+		{"/cars/*[position()=2]/models/*[3]", "X5"},
+		{"/cars/*[name='Ford']/models/*[2]", "Focus"},
+		{"(//models/*)[4]", "320"},
+	} {
+		n := FindOne(doc, tc.expr)
+		if n == nil {
+			t.Fatalf("%v: expected a match", tc.expr)
+		}
+		if g := n.InnerText(); tc.e != g {
+			t.Fatalf("%v: expected %v but %v", tc.expr, tc.e, g)
+		}
+	}
+	if n := FindOne(doc, "/cars/*[4]"); n != nil {
+		t.Fatalf("expected no match but %v", n.InnerText())
+	}
+	// Each models array is numbered on its own.
+	if e, g := 3, len(Find(doc, "//models/*[1]")); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestChildValuePredicates(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ordered, err := ParseWithOrder(strings.NewReader(testJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		expr, e string
+	}{
+		{"//cars/*[name='BMW']/models/*", "320,X3,X5"},
+		{`//cars/*[name="BMW"]/models/*`, "320,X3,X5"},
+		{"/cars/*[name='BMW']/models/*[last()]", "X5"},
+		{"//cars/*[name!='BMW']/name", "Ford,Fiat"},
+		{"//cars/*[name='bmw']/name", ""},
+		{"//cars/*[name='BMW' and models/*='X5']/name", "BMW"},
+		{"//cars/*[name='BMW' or name='Fiat']/name", "BMW,Fiat"},
+		// A comparison with an array is true if any element matches.
+		{"//cars/*[models/*='Panda']/name", "Fiat"},
+		{"//cars/*[models/*[2]='X3']/name", "BMW"},
+		{"//cars/*[starts-with(name,'F')]/name", "Ford,Fiat"},
+		{"//cars/*[contains(name,'MW')]/name", "BMW"},
+		{"//cars/*[count(models/*)=2]/name", "Fiat"},
+		{"//cars/*[not(name='Ford')]/name", "BMW,Fiat"},
+		{"/*[../name='John' and ../motorist='true']/self::age", "30"},
+	} {
+		for _, d := range []*Node{doc, ordered} {
+			var values []string
+			for _, n := range Find(d, tc.expr) {
+				values = append(values, n.InnerText())
+			}
+			if g := strings.Join(values, ","); tc.e != g {
+				t.Fatalf("%v: expected %v but %v", tc.expr, tc.e, g)
+			}
+		}
+	}
+	// The element, not just its text, is selected.
+	car := FindOne(doc, "//cars/*[name='BMW']")
+	if e, g := doc.SelectElement("cars").FirstChild.NextSibling, car; e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestNumericComparisons(t *testing.T) {
+	doc, err := parseString(`{
+		"people": [
+			{"name": "Ann", "age": 9},
+			{"name": "Bob", "age": 18},
+			{"name": "Cat", "age": 100},
+			{"name": "Dan", "age": 1e3},
+			{"name": "Eve", "age": 18.0}
+		],
+		"prices": [1.5, 10, -0.25, 2],
+		"stock": {"apples": 150, "pears": 99.5, "label": "fruit"}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		expr, e string
+	}{
+		{"//age[. > 18]", "100,1e3"},
+		{"//age[. = 18]", "18,18.0"},
+		{"//age[. != 18]", "9,100,1e3"},
+		{"//people/*[age >= 18]/name", "Bob,Cat,Dan,Eve"},
+		{"//people/*[age < 10 or age > 500]/name", "Ann,Dan"},
+		{"//prices/*[. < 2]", "1.5,-0.25"},
+		{"//prices/*[. > 1.5 and . <= 10]", "10,2"},
+		{"//prices/*[position() > 1 and . > 0]", "10,2"},
+		// Numbers are compared by value, not as strings, so "9" is
+		// less than "10".
+		{"//age[. < 10]", "9"},
+		// number() makes the comparison safe for values that are
+		// not numbers, which compare as NaN.
+		{"//stock/*[number(.) > 100]", "150"},
+		{"//stock/*[number(.) != 1]", "150,fruit,99.5"},
+		{"//*[number(.) > 100]", "1e3,150"},
+		{"//people/*[number(name) > 1]", ""},
+	} {
+		var values []string
+		for _, n := range Find(doc, tc.expr) {
+			values = append(values, n.InnerText())
+		}
+		if g := strings.Join(values, ","); tc.e != g {
+			t.Fatalf("%v: expected %v but %v", tc.expr, tc.e, g)
+		}
+	}
+	// Without number(), a value that is not a number cannot be
+	// compared with a number; the query fails instead of panicking.
+	stock, err := parseString(`{"stock":{"apples":150,"label":"fruit"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expr := range []string{"//stock/*[. > 100]", "//stock/*[string(.) > 100]"} {
+		if _, err := QueryAll(stock, expr); err == nil {
+			t.Fatalf("%v: expected an error", expr)
+		}
+		if nodes := stock.SelectElements(expr); nodes != nil {
+			t.Fatalf("%v: expected nil but %v", expr, nodes)
+		}
+		if err := stock.ForEachMatch(expr, func(*Node) error { return nil }); err == nil {
+			t.Fatalf("%v: expected an error", expr)
+		}
+	}
+
+	for _, tc := range []struct {
+		expr string
+		e    interface{}
+	}{
+		{"sum(//prices/*)", 13.25},
+		{"count(//age[. >= 18])", float64(4)},
+		{"number(//people/*[name='Cat']/age) * 2", float64(200)},
+	} {
+		if g := xpath.MustCompile(tc.expr).Evaluate(CreateXPathNavigator(doc)); tc.e != g {
+			t.Fatalf("%v: expected %v but %v", tc.expr, tc.e, g)
+		}
+	}
+}
+
+func TestQueryAllN(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		max int
+		e   string
+	}{
+		{1, "Fiesta"},
+		{4, "Fiesta,Focus,Mustang,320"},
+		{8, "Fiesta,Focus,Mustang,320,X3,X5,500,Panda"},
+		{100, "Fiesta,Focus,Mustang,320,X3,X5,500,Panda"},
+		{0, "Fiesta,Focus,Mustang,320,X3,X5,500,Panda"},
+		{-1, "Fiesta,Focus,Mustang,320,X3,X5,500,Panda"},
+	} {
+		nodes, err := QueryAllN(doc, "//models/*", tc.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var values []string
+		for _, n := range nodes {
+			values = append(values, n.InnerText())
+		}
+		if g := strings.Join(values, ","); tc.e != g {
+			t.Fatalf("%d: expected %v but %v", tc.max, tc.e, g)
+		}
+	}
+	if nodes, err := QueryAllN(doc, "//missing", 3); err != nil || len(nodes) != 0 {
+		t.Fatalf("expected no matches but %v, %v", nodes, err)
+	}
+	if _, err := QueryAllN(doc, "[[", 3); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func BenchmarkQueryAllN(b *testing.B) {
+	var s strings.Builder
+	s.WriteString("[")
+	for i := 0; i < 10000; i++ {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(`{"id":1}`)
+	}
+	s.WriteString("]")
+	doc, err := parseString(s.String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		QueryAllN(doc, "//id", 10)
+	}
+}
+
+func TestFindEach(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var models []string
+	FindEach(doc, "//models/*", func(i int, n *Node) {
+		if i != len(models) {
+			t.Fatalf("expected index %v but %v", len(models), i)
+		}
+		models = append(models, n.InnerText())
+	})
+	if e, g := "Fiesta,Focus,Mustang,320,X3,X5,500,Panda", strings.Join(models, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	models = models[:0]
+	FindEachWithBreak(doc, "//models/*", func(i int, n *Node) bool {
+		models = append(models, n.InnerText())
+		return n.InnerText() != "320"
+	})
+	if e, g := "Fiesta,Focus,Mustang,320", strings.Join(models, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for a malformed query")
+		}
+	}()
+	FindEach(doc, "//models/*[", func(int, *Node) {})
+}
@@ -0,0 +1,137 @@
+package jsonquery
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A PathScanner reads a JSON document as a stream of scalar values
+// together with their JSON Pointer paths, without building a tree.
+// Memory use depends on the nesting depth of the document, not on its
+// size.
+type PathScanner struct {
+	dec    *json.Decoder
+	frames []scanFrame
+	done   bool
+}
+
+type scanFrame struct {
+	array   bool
+	index   int
+	key     string
+	wantKey bool
+}
+
+// NewPathScanner returns a PathScanner reading from r.
+func NewPathScanner(r io.Reader) *PathScanner {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &PathScanner{dec: dec}
+}
+
+// Next returns the next scalar value of the document, in document
+// order, along with its JSON Pointer path, such as "/cars/0/name", and
+// its type. The value of a null is "null". The path of a top-level
+// scalar is the empty string. Next returns io.EOF once the whole
+// document has been read.
+func (s *PathScanner) Next() (path string, value string, kind ValueType, err error) {
+	if s.done {
+		return "", "", 0, io.EOF
+	}
+	for {
+		tok, err := s.dec.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", "", 0, err
+		}
+		if n := len(s.frames); n > 0 && s.frames[n-1].wantKey {
+			if d, ok := tok.(json.Delim); ok && d == '}' {
+				s.frames = s.frames[:n-1]
+				if err := s.endValue(); err != nil || s.done {
+					return "", "", 0, s.stop(err)
+				}
+				continue
+			}
+			s.frames[n-1].key = tok.(string)
+			s.frames[n-1].wantKey = false
+			continue
+		}
+		switch v := tok.(type) {
+		case json.Delim:
+			switch v {
+			case '{':
+				s.frames = append(s.frames, scanFrame{wantKey: true})
+			case '[':
+				s.frames = append(s.frames, scanFrame{array: true})
+			case ']':
+				s.frames = s.frames[:len(s.frames)-1]
+				if err := s.endValue(); err != nil || s.done {
+					return "", "", 0, s.stop(err)
+				}
+			}
+			continue
+		case string:
+			path, value, kind = s.path(), v, StringValue
+		case json.Number:
+			path, value, kind = s.path(), v.String(), NumberValue
+		case bool:
+			path, value, kind = s.path(), strconv.FormatBool(v), BoolValue
+		case nil:
+			path, value, kind = s.path(), "null", NullValue
+		}
+		if err := s.endValue(); err != nil {
+			return "", "", 0, err
+		}
+		return path, value, kind, nil
+	}
+}
+
+// stop returns err, or io.EOF if err is nil.
+func (s *PathScanner) stop(err error) error {
+	if err == nil {
+		return io.EOF
+	}
+	return err
+}
+
+// endValue advances the enclosing container past the value just read.
+func (s *PathScanner) endValue() error {
+	n := len(s.frames)
+	if n == 0 {
+		s.done = true
+		if _, err := s.dec.Token(); err != io.EOF {
+			if err == nil {
+				err = errors.New("jsonquery: unexpected data after top-level value")
+			}
+			return err
+		}
+		return nil
+	}
+	if s.frames[n-1].array {
+		s.frames[n-1].index++
+	} else {
+		s.frames[n-1].wantKey = true
+	}
+	return nil
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// path returns the JSON Pointer of the current value.
+func (s *PathScanner) path() string {
+	var b strings.Builder
+	for _, f := range s.frames {
+		b.WriteByte('/')
+		if f.array {
+			b.WriteString(strconv.Itoa(f.index))
+		} else {
+			b.WriteString(pointerEscaper.Replace(f.key))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package jsonquery
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPathScanner(t *testing.T) {
+	s := NewPathScanner(strings.NewReader(testJSON))
+	var events []string
+	for {
+		path, value, kind, err := s.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, fmt.Sprintf("%s=%s(%d)", path, value, kind))
+	}
+	expected := []string{
+		"/name=John(0)",
+		"/age=30(1)",
+		"/motorist=true(2)",
+		"/cars/0/name=Ford(0)",
+		"/cars/0/models/0=Fiesta(0)",
+		"/cars/0/models/1=Focus(0)",
+		"/cars/0/models/2=Mustang(0)",
+		"/cars/1/name=BMW(0)",
+		"/cars/1/models/0=320(0)",
+		"/cars/1/models/1=X3(0)",
+		"/cars/1/models/2=X5(0)",
+		"/cars/2/name=Fiat(0)",
+		"/cars/2/models/0=500(0)",
+		"/cars/2/models/1=Panda(0)",
+	}
+	if e, g := strings.Join(expected, "\n"), strings.Join(events, "\n"); e != g {
+		t.Fatalf("expected\n%v\nbut\n%v", e, g)
+	}
+	if _, _, _, err := s.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF but %v", err)
+	}
+}
+
+func TestPathScannerEscape(t *testing.T) {
+	s := NewPathScanner(strings.NewReader(`{"a/b":{"c~d":[{},[],{"e":null},1.5]}}`))
+	path, value, kind, err := s.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/a~1b/c~0d/2/e" || value != "null" || kind != NullValue {
+		t.Fatalf("unexpected event %v=%v(%v)", path, value, kind)
+	}
+	path, value, kind, err = s.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/a~1b/c~0d/3" || value != "1.5" || kind != NumberValue {
+		t.Fatalf("unexpected event %v=%v(%v)", path, value, kind)
+	}
+	if _, _, _, err := s.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF but %v", err)
+	}
+
+	s = NewPathScanner(strings.NewReader(`"top"`))
+	if path, value, _, err := s.Next(); err != nil || path != "" || value != "top" {
+		t.Fatalf("unexpected event %q=%v, %v", path, value, err)
+	}
+
+	s = NewPathScanner(strings.NewReader(`{"a":1`))
+	if _, _, _, err := s.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := s.Next(); err == nil || err == io.EOF {
+		t.Fatalf("expected an error for a truncated document but %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package jsonquery
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// arrayStream decodes the elements of a top-level JSON array one at
+// a time. A document that is not an array is returned as a single
+// element.
+type arrayStream struct {
+	p     *parser
+	first json.Token
+	array bool
+	done  bool
+}
+
+func newArrayStream(r io.Reader) (*arrayStream, error) {
+	p := newParser(r, ParserOptions{})
+	tok, err := p.dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	s := &arrayStream{p: p}
+	if tok == json.Delim('[') {
+		s.array = true
+	} else {
+		s.first = tok
+	}
+	return s, nil
+}
+
+// next returns a document for the next element of the array, or
+// io.EOF once all elements have been read.
+func (s *arrayStream) next() (*Node, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	doc := &Node{Type: DocumentNode}
+	if !s.array {
+		s.done = true
+		if err := s.p.parseToken(s.first, doc, 1); err != nil {
+			return nil, err
+		}
+		if err := s.p.end(); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	}
+	if !s.p.dec.More() {
+		s.done = true
+		// Consume the closing bracket.
+		if _, err := s.p.dec.Token(); err != nil {
+			return nil, err
+		}
+		if err := s.p.end(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	if err := s.p.parseValue(doc, 1); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// ParseStream parses the elements of a top-level JSON array read from r
+// one at a time and calls cb with each of them as its own document.
+// Only the element being parsed is held in memory, so arrays larger
+// than the available memory can be processed as long as each element
+// fits. A document that is not an array is passed to cb as a whole.
+// ParseStream stops at and returns the first error returned by cb.
+func ParseStream(r io.Reader, cb func(*Node) error) error {
+	s, err := newArrayStream(r)
+	if err != nil {
+		return err
+	}
+	for {
+		n, err := s.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := cb(n); err != nil {
+			return err
+		}
+	}
+}
+
+// StreamArrayChan parses the elements of a top-level JSON array read
+// from r one at a time and sends each of them, as its own document,
+// on the returned node channel. A document that is not an array is
+// sent as a single node.
+//
+// The node channel is unbuffered, so the parser does not read further
+// elements until the previous one has been received, which lets a
+// slow consumer throttle parsing. Both channels are closed once the
+// input is exhausted, parsing fails or ctx is done; in the latter two
+// cases the error is sent on the error channel, which is buffered so
+// the parsing goroutine never blocks on it. Cancellation is observed
+// between elements, not while blocked reading from r.
+func StreamArrayChan(ctx context.Context, r io.Reader) (<-chan *Node, <-chan error) {
+	nodes := make(chan *Node)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(nodes)
+		defer close(errc)
+		s, err := newArrayStream(r)
+		if err != nil {
+			errc <- err
+			return
+		}
+		for {
+			if err := ctx.Err(); err != nil {
+				errc <- err
+				return
+			}
+			n, err := s.next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case nodes <- n:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return nodes, errc
+}
@@ -0,0 +1,110 @@
+package jsonquery
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStreamArrayChan(t *testing.T) {
+	s := `[
+		{ "name":"Ford", "models":[ "Fiesta", "Focus", "Mustang" ] },
+		{ "name":"BMW", "models":[ "320", "X3", "X5" ] },
+		{ "name":"Fiat", "models":[ "500", "Panda" ] }
+	]`
+	nodes, errc := StreamArrayChan(context.Background(), strings.NewReader(s))
+	var names []string
+	for n := range nodes {
+		if n.Type != DocumentNode {
+			t.Fatal("expected a DocumentNode")
+		}
+		names = append(names, n.SelectElement("name").InnerText())
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "Ford,BMW,Fiat", strings.Join(names, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	nodes, errc = StreamArrayChan(context.Background(), strings.NewReader(testJSON))
+	var count int
+	for n := range nodes {
+		if e, g := "John", n.SelectElement("name").InnerText(); e != g {
+			t.Fatalf("expected %v but %v", e, g)
+		}
+		count++
+	}
+	if err := <-errc; err != nil || count != 1 {
+		t.Fatalf("expected a single document but %v, %v", count, err)
+	}
+
+	nodes, errc = StreamArrayChan(context.Background(), strings.NewReader(`[1, 2,`))
+	for range nodes {
+	}
+	if err := <-errc; err == nil {
+		t.Fatal("expected an error for a truncated array")
+	}
+}
+
+func TestStreamArrayChanCancel(t *testing.T) {
+	s := "[" + strings.Repeat(`{"a":1},`, 100) + `{"a":1}]`
+	ctx, cancel := context.WithCancel(context.Background())
+	nodes, errc := StreamArrayChan(ctx, strings.NewReader(s))
+	if n := <-nodes; n == nil {
+		t.Fatal("expected a node")
+	}
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("expected %v but %v", context.Canceled, err)
+	}
+	if _, ok := <-nodes; ok {
+		t.Fatal("expected the node channel to be closed")
+	}
+}
+
+func TestParseStream(t *testing.T) {
+	var names []string
+	err := ParseStream(strings.NewReader(`[{"name":"Ford"},{"name":"BMW"},{"name":"Fiat"}]`), func(n *Node) error {
+		if n.Type != DocumentNode {
+			t.Fatalf("expected a document but %v", n.Type)
+		}
+		names = append(names, FindOne(n, "name").InnerText())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "Ford,BMW,Fiat", strings.Join(names, ","); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+
+	count := 0
+	err = ParseStream(strings.NewReader(testJSON), func(n *Node) error {
+		count++
+		if e, g := "John", FindOne(n, "name").InnerText(); e != g {
+			t.Fatalf("expected %v but %v", e, g)
+		}
+		return nil
+	})
+	if err != nil || count != 1 {
+		t.Fatalf("expected one document but %v, %v", count, err)
+	}
+
+	stop := errors.New("stop")
+	count = 0
+	err = ParseStream(strings.NewReader(`[1,2,3`), func(n *Node) error {
+		count++
+		if count == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || count != 2 {
+		t.Fatalf("expected to stop after two elements but %v, %v", count, err)
+	}
+	if err := ParseStream(strings.NewReader(`[1,2,3`), func(*Node) error { return nil }); err == nil {
+		t.Fatal("expected an error for a truncated array")
+	}
+}
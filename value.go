@@ -0,0 +1,371 @@
+package jsonquery
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IsNull reports whether n holds the JSON null, that is whether n is a
+// text node of type NullValue or an element whose value is one.
+func (n *Node) IsNull() bool {
+	t := scalar(n)
+	return t != nil && t.ValueType == NullValue
+}
+
+// IsEmpty reports whether n holds no value:
+//
+//   - a text node is empty if its Data is the empty string, which
+//     includes null;
+//   - an element or document is empty if it has no children, which is
+//     the case for an empty object and an empty array, or if its value
+//     is null or an empty string.
+//
+// Numbers, booleans and containers with at least one member are never
+// empty.
+func (n *Node) IsEmpty() bool {
+	if t := scalar(n); t != nil {
+		return t.Data == ""
+	}
+	return n.FirstChild == nil
+}
+
+// ToValue returns the value of n as the Go value json.Unmarshal
+// would produce for it: a map[string]interface{} for an object, an
+// []interface{} for an array, a string, float64 or bool for a scalar,
+// and nil for null. An element without children whose Container is not
+// set, which can only be told apart from an empty object or array by
+// that field, also yields nil.
+func (n *Node) ToValue() interface{} {
+	if t := scalar(n); t != nil {
+		switch t.ValueType {
+		case NumberValue:
+			f, _ := strconv.ParseFloat(t.Data, 64)
+			return f
+		case BoolValue:
+			return t.Data == "true"
+		case NullValue:
+			return nil
+		}
+		return t.Data
+	}
+	if n.FirstChild == nil && n.Container == NoContainer {
+		return nil
+	}
+	if n.IsArray() {
+		a := []interface{}{}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			a = append(a, child.ToValue())
+		}
+		return a
+	}
+	m := make(map[string]interface{})
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		m[child.Data] = child.ToValue()
+	}
+	return m
+}
+
+// ToInterface is the same as ToValue.
+//
+// Deprecated: Use ToValue.
+func (n *Node) ToInterface() interface{} {
+	return n.ToValue()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// As converts the inner text of n to the type that target points to
+// and stores the result in it. target must be a non-nil pointer to a
+// string, a bool, an integer or floating-point type, or a time.Time,
+// which is parsed as RFC 3339.
+func (n *Node) As(target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("jsonquery: As requires a non-nil pointer, got %T", target)
+	}
+	s := n.InnerText()
+	e := v.Elem()
+	var err error
+	switch {
+	case e.Type() == timeType:
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339, s); err == nil {
+			e.Set(reflect.ValueOf(t))
+		}
+	case e.Kind() == reflect.String:
+		e.SetString(s)
+	case e.Kind() == reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(s); err == nil {
+			e.SetBool(b)
+		}
+	case e.Kind() >= reflect.Int && e.Kind() <= reflect.Int64:
+		var i int64
+		if i, err = strconv.ParseInt(s, 10, e.Type().Bits()); err == nil {
+			e.SetInt(i)
+		}
+	case e.Kind() >= reflect.Uint && e.Kind() <= reflect.Uint64:
+		var u uint64
+		if u, err = strconv.ParseUint(s, 10, e.Type().Bits()); err == nil {
+			e.SetUint(u)
+		}
+	case e.Kind() == reflect.Float32 || e.Kind() == reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(s, e.Type().Bits()); err == nil {
+			e.SetFloat(f)
+		}
+	default:
+		return fmt.Errorf("jsonquery: As does not support %v", e.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("jsonquery: cannot convert %q to %v: %v", s, e.Type(), err)
+	}
+	return nil
+}
+
+// lookup resolves path relative to n. A path starting with "/" is a
+// JSON Pointer; any other path is a list of keys separated by dots.
+// Numeric segments select array elements by their 0-based index.
+func (n *Node) lookup(path string) *Node {
+	var segments []string
+	if strings.HasPrefix(path, "/") {
+		segments = pointerSegments(path)
+	} else if path != "" {
+		segments = strings.Split(path, ".")
+	}
+	m, _ := n.resolve(segments)
+	return m
+}
+
+// pointerSegments splits the JSON Pointer p, which starts with "/",
+// into its unescaped reference tokens.
+func pointerSegments(p string) []string {
+	segments := strings.Split(p[1:], "/")
+	for i, s := range segments {
+		segments[i] = strings.Replace(strings.Replace(s, "~1", "/", -1), "~0", "~", -1)
+	}
+	return segments
+}
+
+// resolve follows segments from n, selecting object members by key and
+// array elements by index.
+func (n *Node) resolve(segments []string) (*Node, error) {
+	for _, s := range segments {
+		if scalar(n) != nil {
+			return nil, fmt.Errorf("cannot select %q in a scalar value", s)
+		}
+		var next *Node
+		if n.IsArray() {
+			i, ok := arrayIndex(s)
+			if !ok {
+				return nil, fmt.Errorf("invalid array index %q", s)
+			}
+			for child := n.FirstChild; child != nil && next == nil; child = child.NextSibling {
+				if i == 0 {
+					next = child
+				}
+				i--
+			}
+			if next == nil {
+				return nil, fmt.Errorf("array index %s out of range", s)
+			}
+		} else {
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				if child.Type == ElementNode && child.Data == s {
+					next = child
+					break
+				}
+			}
+			if next == nil {
+				return nil, fmt.Errorf("no member %q", s)
+			}
+		}
+		n = next
+	}
+	return n, nil
+}
+
+// arrayIndex parses s as an array index, which is either 0 or digits
+// without a leading zero.
+func arrayIndex(s string) (int, bool) {
+	if s == "" || len(s) > 1 && s[0] == '0' {
+		return 0, false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	i, err := strconv.Atoi(s)
+	return i, err == nil
+}
+
+// EvalPointer returns the node referred to by the JSON Pointer pointer
+// (RFC 6901) relative to n. Each reference token of pointer, with ~1
+// and ~0 unescaped to "/" and "~", selects a member of an object by
+// key or an element of an array by its 0-based index. The empty
+// pointer refers to n itself. It returns an error if pointer is not
+// empty and does not start with "/", or if a token does not match a
+// member or element, for example because an index is out of range.
+func (n *Node) EvalPointer(pointer string) (*Node, error) {
+	if pointer == "" {
+		return n, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("jsonquery: invalid JSON pointer %q: must start with \"/\"", pointer)
+	}
+	m, err := n.resolve(pointerSegments(pointer))
+	if err != nil {
+		return nil, fmt.Errorf("jsonquery: JSON pointer %q: %v", pointer, err)
+	}
+	return m, nil
+}
+
+// text returns the text of the scalar held by n, or an error if n does
+// not hold a scalar.
+func (n *Node) text() (string, error) {
+	t := scalar(n)
+	if t == nil {
+		return "", errors.New("jsonquery: node does not hold a scalar value")
+	}
+	return t.Data, nil
+}
+
+// Int returns the scalar value of n, or of its text child, as an
+// integer. Numbers written with a fraction or exponent are accepted if
+// their value is integral, so 30.0 and 3e1 both yield 30, but 30.5 is
+// an error rather than being truncated. It also returns an error if the
+// value does not fit in an int64.
+func (n *Node) Int() (int64, error) {
+	s, err := n.text()
+	if err != nil {
+		return 0, err
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, fmt.Errorf("jsonquery: %q is not an integer", s)
+	}
+	return int64(f), nil
+}
+
+// Float64 returns the scalar value of n, or of its text child, as a
+// floating-point number.
+func (n *Node) Float64() (float64, error) {
+	s, err := n.text()
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("jsonquery: %q is not a number", s)
+	}
+	return f, nil
+}
+
+// Bool returns the scalar value of n, or of its text child, as a
+// boolean, accepting the values strconv.ParseBool does.
+func (n *Node) Bool() (bool, error) {
+	s, err := n.text()
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("jsonquery: %q is not a boolean", s)
+	}
+	return b, nil
+}
+
+// lookupScalar returns the text node holding the scalar at path if it
+// has the JSON type typ.
+func (n *Node) lookupScalar(path string, typ ValueType) *Node {
+	if m := n.lookup(path); m != nil {
+		if t := scalar(m); t != nil && t.ValueType == typ {
+			return t
+		}
+	}
+	return nil
+}
+
+// GetString returns the string at path, which is either a list of keys
+// separated by dots such as "server.tls.host" or a JSON Pointer such as
+// "/server/tls/host". Numeric segments index into arrays. It returns
+// def if any segment is missing or the value is not a JSON string.
+func (n *Node) GetString(path string, def string) string {
+	if t := n.lookupScalar(path, StringValue); t != nil {
+		return t.Data
+	}
+	return def
+}
+
+// GetInt is like GetString but returns the integer at path, or def if
+// the value is missing or is not an integral JSON number.
+func (n *Node) GetInt(path string, def int) int {
+	if t := n.lookupScalar(path, NumberValue); t != nil {
+		if i, err := strconv.Atoi(t.Data); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+// GetBool is like GetString but returns the boolean at path, or def
+// if the value is missing or is not a JSON boolean.
+func (n *Node) GetBool(path string, def bool) bool {
+	if t := n.lookupScalar(path, BoolValue); t != nil {
+		if b, err := strconv.ParseBool(t.Data); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// QueryStringOr returns the inner text of the first child element
+// matching query, or def if nothing matches or query cannot be parsed.
+func (n *Node) QueryStringOr(query, def string) string {
+	if m, err := Query(n, query); err == nil && m != nil {
+		return m.InnerText()
+	}
+	return def
+}
+
+// QueryIntOr is like QueryStringOr but returns the first match as an
+// integer, or def if it is missing or not an integer.
+func (n *Node) QueryIntOr(query string, def int) int {
+	if m, err := Query(n, query); err == nil && m != nil {
+		if i, err := strconv.Atoi(m.InnerText()); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+// QueryFloatOr is like QueryStringOr but returns the first match as a
+// float64, or def if it is missing or not a number.
+func (n *Node) QueryFloatOr(query string, def float64) float64 {
+	if m, err := Query(n, query); err == nil && m != nil {
+		if f, err := strconv.ParseFloat(m.InnerText(), 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+// QueryBoolOr is like QueryStringOr but returns the first match as a
+// bool, or def if it is missing or not a boolean.
+func (n *Node) QueryBoolOr(query string, def bool) bool {
+	if m, err := Query(n, query); err == nil && m != nil {
+		if b, err := strconv.ParseBool(m.InnerText()); err == nil {
+			return b
+		}
+	}
+	return def
+}
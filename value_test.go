@@ -0,0 +1,422 @@
+package jsonquery
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAs(t *testing.T) {
+	doc, err := parseString(`{
+		"name":"John",
+		"age":30,
+		"motorist":true,
+		"height":1.85,
+		"born":"1990-04-01T08:30:00Z"
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := doc.SelectElement("name").As(&s); err != nil || s != "John" {
+		t.Fatalf("expected John but %v, %v", s, err)
+	}
+	var i int
+	if err := doc.SelectElement("age").As(&i); err != nil || i != 30 {
+		t.Fatalf("expected 30 but %v, %v", i, err)
+	}
+	var i8 int8
+	if err := doc.SelectElement("age").As(&i8); err != nil || i8 != 30 {
+		t.Fatalf("expected 30 but %v, %v", i8, err)
+	}
+	var u uint16
+	if err := doc.SelectElement("age").As(&u); err != nil || u != 30 {
+		t.Fatalf("expected 30 but %v, %v", u, err)
+	}
+	var f float64
+	if err := doc.SelectElement("height").As(&f); err != nil || f != 1.85 {
+		t.Fatalf("expected 1.85 but %v, %v", f, err)
+	}
+	var b bool
+	if err := doc.SelectElement("motorist").As(&b); err != nil || !b {
+		t.Fatalf("expected true but %v, %v", b, err)
+	}
+	var born time.Time
+	if err := doc.SelectElement("born").As(&born); err != nil {
+		t.Fatal(err)
+	}
+	if e := time.Date(1990, 4, 1, 8, 30, 0, 0, time.UTC); !born.Equal(e) {
+		t.Fatalf("expected %v but %v", e, born)
+	}
+}
+
+func TestAsErrors(t *testing.T) {
+	doc, err := parseString(`{"name":"John","height":1.85,"age":300}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var i int
+	if err := doc.SelectElement("name").As(&i); err == nil {
+		t.Fatal("expected an error converting a string to int")
+	}
+	if err := doc.SelectElement("height").As(&i); err == nil {
+		t.Fatal("expected an error converting a fraction to int")
+	}
+	var i8 int8
+	if err := doc.SelectElement("age").As(&i8); err == nil {
+		t.Fatal("expected an error for an out of range value")
+	}
+	var b bool
+	if err := doc.SelectElement("name").As(&b); err == nil {
+		t.Fatal("expected an error converting a string to bool")
+	}
+	var m map[string]string
+	if err := doc.SelectElement("name").As(&m); err == nil {
+		t.Fatal("expected an error for an unsupported type")
+	}
+	if err := doc.SelectElement("name").As(i); err == nil {
+		t.Fatal("expected an error for a non-pointer target")
+	}
+}
+
+func TestGetWithDefault(t *testing.T) {
+	doc, err := parseString(`{
+		"server": {
+			"host": "example.com",
+			"tls": {"port": 8443, "enabled": true},
+			"a/b": {"c~d": "escaped"}
+		},
+		"cars": [{"name":"Ford"},{"name":"BMW"}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// present
+	if e, g := "example.com", doc.GetString("server.host", "localhost"); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 8443, doc.GetInt("server.tls.port", 443); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := true, doc.GetBool("server.tls.enabled", false); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "BMW", doc.GetString("cars.1.name", ""); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 8443, doc.GetInt("/server/tls/port", 443); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "escaped", doc.GetString("/server/a~1b/c~0d", ""); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	// missing
+	if e, g := 443, doc.GetInt("server.http.port", 443); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "none", doc.GetString("cars.2.name", "none"); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := false, doc.GetBool("missing", false); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	// wrong type
+	if e, g := 443, doc.GetInt("server.host", 443); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "default", doc.GetString("server.tls.port", "default"); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "default", doc.GetString("server.tls", "default"); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := true, doc.GetBool("server.host", true); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestEvalPointer(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		pointer, e string
+	}{
+		{"/name", `"John"`},
+		{"/cars/0/models/2", `"Mustang"`},
+		{"/cars/1", `{"models":["320","X3","X5"],"name":"BMW"}`},
+		{"/cars/2/models", `["500","Panda"]`},
+	} {
+		n, err := doc.EvalPointer(tc.pointer)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.pointer, err)
+		}
+		if g := n.OutputJSON(); tc.e != g {
+			t.Fatalf("%s: expected %v but %v", tc.pointer, tc.e, g)
+		}
+	}
+	if n, err := doc.EvalPointer(""); err != nil || n != doc {
+		t.Fatalf("expected the document but %v, %v", n, err)
+	}
+
+	doc, err = parseString(`{"a/b":{"c~d":1,"":2,"~01":3}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, e := range map[string]string{"/a~1b/c~0d": "1", "/a~1b/": "2", "/a~1b/~001": "3"} {
+		n, err := doc.EvalPointer(p)
+		if err != nil {
+			t.Fatalf("%s: %v", p, err)
+		}
+		if g := n.InnerText(); e != g {
+			t.Fatalf("%s: expected %v but %v", p, e, g)
+		}
+	}
+
+	doc, err = parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, e := range map[string]string{
+		"name":              `jsonquery: invalid JSON pointer "name": must start with "/"`,
+		"/missing":          `jsonquery: JSON pointer "/missing": no member "missing"`,
+		"/cars/3":           `jsonquery: JSON pointer "/cars/3": array index 3 out of range`,
+		"/cars/-":           `jsonquery: JSON pointer "/cars/-": invalid array index "-"`,
+		"/cars/01":          `jsonquery: JSON pointer "/cars/01": invalid array index "01"`,
+		"/cars/name":        `jsonquery: JSON pointer "/cars/name": invalid array index "name"`,
+		"/name/0":           `jsonquery: JSON pointer "/name/0": cannot select "0" in a scalar value`,
+		"/cars/0/models/x/": `jsonquery: JSON pointer "/cars/0/models/x/": invalid array index "x"`,
+	} {
+		n, err := doc.EvalPointer(p)
+		if err == nil {
+			t.Fatalf("%s: expected an error but %v", p, n)
+		}
+		if g := err.Error(); e != g {
+			t.Fatalf("expected %v but %v", e, g)
+		}
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	doc, err := parseString(`{
+		"object": {},
+		"array": [],
+		"string": "",
+		"null": null,
+		"zero": 0,
+		"false": false,
+		"text": "x",
+		"items": [""],
+		"member": {"a": null}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{
+		"object": true,
+		"array":  true,
+		"string": true,
+		"null":   true,
+		"zero":   false,
+		"false":  false,
+		"text":   false,
+		"items":  false,
+		"member": false,
+	}
+	for key, e := range expected {
+		if g := doc.SelectElement(key).IsEmpty(); e != g {
+			t.Fatalf("%v: expected %v but %v", key, e, g)
+		}
+	}
+	if !doc.SelectElement("string").FirstChild.IsEmpty() {
+		t.Fatal("an empty text node should be empty")
+	}
+	if doc.SelectElement("text").FirstChild.IsEmpty() {
+		t.Fatal("a non-empty text node should not be empty")
+	}
+	if doc.IsEmpty() {
+		t.Fatal("document should not be empty")
+	}
+}
+
+func TestTypedAccessors(t *testing.T) {
+	doc, err := parseString(`{"age":30,"float":30.0,"exp":3e1,"frac":30.5,"big":1e19,"neg":-7,"str":"42",
+		"ratio":0.25,"yes":true,"no":"false","name":"John","cars":["BMW"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, e := range map[string]int64{"age": 30, "float": 30, "exp": 30, "neg": -7, "str": 42} {
+		if g, err := doc.SelectElement(key).Int(); err != nil || e != g {
+			t.Fatalf("%v: expected %v but %v, %v", key, e, g, err)
+		}
+	}
+	for _, key := range []string{"frac", "big", "name", "yes", "cars"} {
+		if _, err := doc.SelectElement(key).Int(); err == nil {
+			t.Fatalf("%v: expected an error", key)
+		}
+	}
+	if g, err := doc.SelectElement("ratio").Float64(); err != nil || g != 0.25 {
+		t.Fatalf("expected 0.25 but %v, %v", g, err)
+	}
+	if g, err := doc.SelectElement("age/text()").Float64(); err != nil || g != 30 {
+		t.Fatalf("expected 30 but %v, %v", g, err)
+	}
+	if _, err := doc.SelectElement("name").Float64(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if g, err := doc.SelectElement("yes").Bool(); err != nil || !g {
+		t.Fatalf("expected true but %v, %v", g, err)
+	}
+	if g, err := doc.SelectElement("no").Bool(); err != nil || g {
+		t.Fatalf("expected false but %v, %v", g, err)
+	}
+	if _, err := doc.SelectElement("name").Bool(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestIsNull(t *testing.T) {
+	doc, err := parseString(`{"first_name":"John","middle_name":null,"tags":[null,""]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	middle := doc.SelectElement("middle_name")
+	if middle == nil {
+		t.Fatal("expected a node for an explicit null")
+	}
+	if !middle.IsNull() || !middle.FirstChild.IsNull() {
+		t.Fatal("expected middle_name to be null")
+	}
+	if doc.SelectElement("last_name") != nil {
+		t.Fatal("expected no node for an absent key")
+	}
+	if doc.SelectElement("first_name").IsNull() || doc.SelectElement("tags").IsNull() {
+		t.Fatal("expected a non-null value")
+	}
+	if !doc.SelectElement("tags/*[1]").IsNull() || doc.SelectElement("tags/*[2]").IsNull() {
+		t.Fatal("expected only the first tag to be null")
+	}
+	if e, g := "", middle.InnerText(); e != g {
+		t.Fatalf("expected %q but %q", e, g)
+	}
+	if e, g := `{"first_name":"John","middle_name":null,"tags":[null,""]}`, doc.OutputJSONDepth(0); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := `<?xml version="1.0"?><first_name>John</first_name><middle_name></middle_name><tags><element></element><element></element></tags>`, doc.OutputXML(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := `<?xml version="1.0"?><first_name>John</first_name><middle_name/><tags><element/><element></element></tags>`, doc.OutputXMLWithOptions(XMLOptions{SelfClose: true}); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestQueryOr(t *testing.T) {
+	doc, err := parseString(testJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// match
+	if e, g := "John", doc.QueryStringOr("name", "nobody"); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 30, doc.QueryIntOr("age", -1); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 30.0, doc.QueryFloatOr("age", -1); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := true, doc.QueryBoolOr("motorist", false); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	// no match
+	if e, g := "nobody", doc.QueryStringOr("city", "nobody"); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := -1, doc.QueryIntOr("height", -1); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 1.5, doc.QueryFloatOr("height", 1.5); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := true, doc.QueryBoolOr("licensed", true); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	// not convertible
+	if e, g := -1, doc.QueryIntOr("name", -1); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 1.5, doc.QueryFloatOr("name", 1.5); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := false, doc.QueryBoolOr("age", false); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	// query error
+	if e, g := "nobody", doc.QueryStringOr("[[", "nobody"); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := -1, doc.QueryIntOr("[[", -1); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := 1.5, doc.QueryFloatOr("[[", 1.5); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := true, doc.QueryBoolOr("[[", true); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestToValueTypes(t *testing.T) {
+	s := `{"name":"John","age":30.5,"motorist":true,"ratio":-1e3,"cars":[{"name":"Ford","models":["Fiesta",1]},[true,false]]}`
+	doc, err := parseString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e interface{}
+	if err := json.Unmarshal([]byte(s), &e); err != nil {
+		t.Fatal(err)
+	}
+	if g := doc.ToValue(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if e, g := "Fiesta", FindOne(doc, "//models/*[1]").ToValue(); e != g {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	empty, err := parseString(`{"a":[],"b":null,"c":{}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = map[string]interface{}{"a": []interface{}{}, "b": nil, "c": map[string]interface{}{}}
+	if g := empty.ToValue(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+	if g := (&Node{Type: ElementNode}).ToValue(); g != nil {
+		t.Fatalf("expected nil but %v", g)
+	}
+	if g := empty.ToInterface(); !reflect.DeepEqual(e, g) {
+		t.Fatalf("expected %v but %v", e, g)
+	}
+}
+
+func TestToValue(t *testing.T) {
+	for _, s := range []string{
+		testJSON,
+		`{"id":7,"price":1.25,"tags":[],"meta":{},"deleted":false,"parent":null,"nested":[[1,"a"],{"b":[true]}]}`,
+		`[1,"two",null]`,
+		`"scalar"`,
+	} {
+		doc, err := parseString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e interface{}
+		if err := json.Unmarshal([]byte(s), &e); err != nil {
+			t.Fatal(err)
+		}
+		if g := doc.ToValue(); !reflect.DeepEqual(e, g) {
+			t.Fatalf("expected %#v but %#v", e, g)
+		}
+	}
+}